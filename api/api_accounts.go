@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Accounts are optional. Users signed up with just a name only ever have
+// API keys; those with an email and password can also sign in for a session
+
+const minPasswordLength = 8
+
+// bcrypt ignores everything past 72 bytes, so longer passwords are refused
+// rather than silently truncated
+const maxPasswordLength = 72
+const maxEmailLength = 254
+
+// Compared against when there's no real hash, so a login for an unknown
+// email takes as long as one with the wrong password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// Emails are compared lowercased. Display names ("Jo <jo@example.com>")
+// aren't accepted - just the address
+func validateEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if email == "" {
+		return "", errors.New("email is required")
+	}
+
+	if len(email) > maxEmailLength {
+		return "", errors.New("email is too long")
+	}
+
+	address, err := mail.ParseAddress(email)
+
+	if err != nil || address.Address != email {
+		return "", errors.New("email is not a valid address")
+	}
+
+	return email, nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return errors.New("password must be at least 8 characters")
+	}
+
+	if len(password) > maxPasswordLength {
+		return errors.New("password must be at most 72 bytes")
+	}
+
+	return nil
+}
+
+func hashPassword(password string) (sql.NullString, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	return nullableString(string(hash)), nil
+}
+
+// False for users without a password, after the same amount of work
+func checkPassword(hash sql.NullString, password string) bool {
+	if !hash.Valid {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(password)) == nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// PUT /api/users/password
+// Signs the user out of every other session
+func (config *ApiConfig) ChangePassword(w http.ResponseWriter, r *http.Request, user database.User) {
+	decoder := json.NewDecoder(r.Body)
+	requestParams := changePasswordRequest{}
+	err := decoder.Decode(&requestParams)
+
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if !user.PasswordHash.Valid {
+		errorResponse(w, http.StatusBadRequest, "User has no password")
+		return
+	}
+
+	if !checkPassword(user.PasswordHash, requestParams.CurrentPassword) {
+		errorResponse(w, http.StatusForbidden, "Current password is incorrect")
+		return
+	}
+
+	err = validatePassword(requestParams.NewPassword)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	passwordHash, err := hashPassword(requestParams.NewPassword)
+
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error changing password")
+		return
+	}
+
+	// Requests made with an API key have no session to keep, so all of them go
+	sessionId, _ := requestSession(r)
+	err = config.updatePassword(r.Context(), user.ID, passwordHash, sessionId)
+
+	if err != nil {
+		log.Printf("Error changing password: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error changing password")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return
+}
+
+// Sessions other than keepSession are ended along with the password change
+func (config *ApiConfig) updatePassword(ctx context.Context, userId uuid.UUID, passwordHash sql.NullString, keepSession uuid.UUID) error {
+	tx, err := config.Db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+	queries := config.DbConn.WithTx(tx)
+
+	err = queries.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+		ID:           userId,
+		PasswordHash: passwordHash,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	err = queries.DeleteOtherSessions(ctx, database.DeleteOtherSessionsParams{
+		UserID: userId,
+		ID:     keepSession,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
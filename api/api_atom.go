@@ -0,0 +1,130 @@
+package api
+
+import "strings"
+
+// Atom 1.0 feeds (RFC 4287)
+// Used by GitHub releases and most Hugo/Jekyll blogs
+
+const atomNamespace = "http://www.w3.org/2005/Atom"
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// Atom text constructs can be text, escaped HTML or inline XHTML
+type atomText struct {
+	Type     string `xml:"type,attr"`
+	Text     string `xml:",chardata"`
+	InnerXml string `xml:",innerxml"`
+}
+
+type atomEntry struct {
+	Title     atomText   `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Id        string     `xml:"id"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+	Summary   atomText   `xml:"summary"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomFeed struct {
+	Title    atomText   `xml:"title"`
+	Subtitle atomText   `xml:"subtitle"`
+	Links    []atomLink `xml:"link"`
+	Id       string     `xml:"id"`
+	Updated  string     `xml:"updated"`
+	Icon     string     `xml:"icon"`
+	Logo     string     `xml:"logo"`
+	// The syndication module turns up in Atom feeds too
+	UpdatePeriod    string      `xml:"http://purl.org/rss/1.0/modules/syndication/ updatePeriod"`
+	UpdateFrequency string      `xml:"http://purl.org/rss/1.0/modules/syndication/ updateFrequency"`
+	Entries         []atomEntry `xml:"entry"`
+}
+
+func (text atomText) String() string {
+	if text.Type == "xhtml" {
+		return strings.TrimSpace(text.InnerXml)
+	}
+
+	return strings.TrimSpace(text.Text)
+}
+
+// Type is text if not given. XHTML is stored as HTML
+func (text atomText) mediaType() string {
+	if text.Type == "html" || text.Type == "xhtml" {
+		return contentTypeHtml
+	}
+
+	return contentTypeText
+}
+
+// A link with no rel attribute is an alternate link per the spec
+func alternateLink(links []atomLink) string {
+	for _, link := range links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return link.Href
+		}
+	}
+
+	if len(links) > 0 {
+		return links[0].Href
+	}
+
+	return ""
+}
+
+func mapAtomEntry(entry atomEntry) rssItem {
+	pubDate := entry.Published
+	if pubDate == "" {
+		pubDate = entry.Updated
+	}
+
+	content := entry.Content.String()
+	description := entry.Summary.String()
+	if description == "" {
+		description = content
+	}
+
+	return rssItem{
+		Title:       entry.Title.String(),
+		Link:        alternateLink(entry.Links),
+		PubDate:     pubDate,
+		Guid:        entry.Id,
+		Description: description,
+		Content:     content,
+		ContentType: entry.Content.mediaType(),
+	}
+}
+
+// Logos are meant to be the larger of the two, so prefer them
+func (feed *atomFeed) image() string {
+	if feed.Logo != "" {
+		return strings.TrimSpace(feed.Logo)
+	}
+
+	return strings.TrimSpace(feed.Icon)
+}
+
+// Map onto the RSS structs so processFeed doesn't need to care about the format
+func (feed *atomFeed) toRss() *rss {
+	channel := rssChannel{
+		Title:           feed.Title.String(),
+		Link:            alternateLink(feed.Links),
+		Description:     feed.Subtitle.String(),
+		LastBuildDate:   feed.Updated,
+		Image:           rssImage{Url: feed.image()},
+		UpdatePeriod:    feed.UpdatePeriod,
+		UpdateFrequency: feed.UpdateFrequency,
+	}
+
+	for _, entry := range feed.Entries {
+		channel.Items = append(channel.Items, mapAtomEntry(entry))
+	}
+
+	return &rss{
+		Channels: []rssChannel{channel},
+	}
+}
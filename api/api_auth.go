@@ -1,8 +1,8 @@
 package api
 
 import (
-	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -12,27 +12,75 @@ import (
 
 type authorisedMethod func(http.ResponseWriter, *http.Request, database.User)
 
-func (config *ApiConfig) AuthMiddleware(method authorisedMethod) http.HandlerFunc {
-	// No body expected: just API key header
+// Requests are made with an API key, or from the web frontend with a
+// session cookie. The key must carry scope, or the request is refused with a
+// 403 naming it. Sessions have every scope
+func (config *ApiConfig) AuthMiddleware(scope string, method authorisedMethod) http.HandlerFunc {
+	// No body expected: just API key header or session cookie
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		log.Printf("Now in auth middleware")
 
+		// An explicit API key wins over any cookie the client also sent
+		if r.Header.Get("Authorization") == "" {
+			if cookie, err := r.Cookie(sessionCookieName); err == nil {
+				config.sessionAuth(w, r, cookie.Value, method)
+				return
+			}
+		}
+
 		key, err := config.getAuthFromHeader(r, "ApiKey")
 		if err != nil {
 			errorResponse(w, http.StatusUnauthorized, "Bad authorization header")
 			return
 		}
 
-		log.Printf("Received API key: %v", key)
-		usr, err := config.DbConn.GetUserByApiKey(context.TODO(), key)
+		// Never log the key itself
+		row, err := config.DbConn.GetUserByApiKey(r.Context(), hashToken(key))
 
 		if err != nil {
 			errorResponse(w, http.StatusUnauthorized, "Bad API key")
 			return
 		}
 
-		method(w, r, usr)
+		if !hasScope(row.ApiKeyScopes, scope) {
+			errorResponse(w, http.StatusForbidden, fmt.Sprintf("API key is missing scope %v", scope))
+			return
+		}
+
+		err = config.DbConn.TouchApiKey(r.Context(), row.ApiKeyID)
+		if err != nil {
+			log.Printf("Error recording use of API key %v: %v", row.ApiKeyID, err)
+		}
+
+		method(w, withScopes(r, row.ApiKeyScopes), database.User{
+			ID:           row.ID,
+			CreatedAt:    row.CreatedAt,
+			UpdatedAt:    row.UpdatedAt,
+			Name:         row.Name,
+			Email:        row.Email,
+			PasswordHash: row.PasswordHash,
+		})
+	})
+}
+
+func (config *ApiConfig) sessionAuth(w http.ResponseWriter, r *http.Request, token string, method authorisedMethod) {
+	row, err := config.DbConn.GetUserBySession(r.Context(), hashToken(token))
+
+	if err != nil {
+		config.clearSessionCookie(w)
+		errorResponse(w, http.StatusUnauthorized, "Session expired or invalid")
+		return
+	}
+
+	r = withSession(withScopes(r, sessionScopes), row.SessionID)
+	method(w, r, database.User{
+		ID:           row.ID,
+		CreatedAt:    row.CreatedAt,
+		UpdatedAt:    row.UpdatedAt,
+		Name:         row.Name,
+		Email:        row.Email,
+		PasswordHash: row.PasswordHash,
 	})
 }
 
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// Feeds and pages are fetched from URLs users hand us, so without a check
+// they could point the server at its own network - localhost, the cloud
+// metadata service at 169.254.169.254 and so on.
+// The dialer refuses anything but public addresses. It runs for every
+// connection, after DNS, so redirects and rebinding are covered too
+
+var errNonPublicAddress = errors.New("address is not public")
+
+// Special-purpose ranges that net/netip still counts as global unicast
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func dialPublicOnly(network string, address string, conn syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+
+	if err != nil || !isPublicAddr(addrPort.Addr()) {
+		return errNonPublicAddress
+	}
+
+	return nil
+}
+
+func newFeedClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialPublicOnly,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be the only address the dialer ever saw
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Transport: transport}
+}
+
+// Used for every request to a URL that came from a user or a feed
+var feedClient = newFeedClient()
@@ -1,8 +1,17 @@
 package api
 
-import "github.com/ajpotts01/go-blog-aggregator/internal/database"
+import (
+	"database/sql"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+)
 
 type ApiConfig struct {
-	DbConn            *database.Queries
-	MaxFeedsProcessed int
+	DbConn *database.Queries
+	// For transactions - queries go through DbConn
+	Db *sql.DB
+	// Off only for local development over plain HTTP
+	SecureCookies bool
+	// Nil unless single sign-on is set up
+	Oidc *OidcProvider
 }
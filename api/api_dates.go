@@ -0,0 +1,117 @@
+package api
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The RSS spec says RFC822, Atom and JSON Feed say RFC3339.
+// In practice publishers send just about anything, so normalise the usual
+// malformations and then try each layout in turn.
+
+// Weekdays add nothing and are often wrong or misspelt ("Thurs,"), so they get
+// stripped. Only weekdays though - month-first dates keep their month
+var leadingWeekday = regexp.MustCompile(`^(?i:mon|tue|tues|wed|thu|thur|thurs|fri|sat|sun)[A-Za-z]*,?\s+`)
+
+// Go only understands zone abbreviations for the local location - anything
+// else parses as UTC, which is wrong for the US zones RFC822 allows
+var namedZoneOffsets = map[string]string{
+	"UT":   "+0000",
+	"UTC":  "+0000",
+	"GMT":  "+0000",
+	"Z":    "+0000",
+	"EST":  "-0500",
+	"EDT":  "-0400",
+	"CST":  "-0600",
+	"CDT":  "-0500",
+	"MST":  "-0700",
+	"MDT":  "-0600",
+	"PST":  "-0800",
+	"PDT":  "-0700",
+	"AKST": "-0900",
+	"AKDT": "-0800",
+	"HST":  "-1000",
+	"BST":  "+0100",
+	"IST":  "+0530",
+	"CET":  "+0100",
+	"CEST": "+0200",
+	"EET":  "+0200",
+	"EEST": "+0300",
+	"JST":  "+0900",
+	"KST":  "+0900",
+	"AWST": "+0800",
+	"ACST": "+0930",
+	"ACDT": "+1030",
+	"AEST": "+1000",
+	"AEDT": "+1100",
+	"NZST": "+1200",
+	"NZDT": "+1300",
+}
+
+// Most common first. Weekdays have already been stripped by this point
+var publishDateLayouts = []string{
+	// RFC822/RFC1123 and friends
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -07:00",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04:05",
+	"2 Jan 06 15:04 -0700",
+	"2 Jan 06 15:04",
+	"2 January 2006 15:04:05 -0700",
+	"2 January 2006 15:04 -0700",
+	"Jan 2 2006 15:04:05 -0700",
+	"Jan 2, 2006 15:04:05 -0700",
+	"Jan 2 15:04:05 2006",
+	"2 Jan 2006",
+	"January 2, 2006",
+	// ISO 8601/RFC3339
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02",
+}
+
+func normalisePublishDate(raw string) string {
+	value := strings.Join(strings.Fields(raw), " ")
+	value = leadingWeekday.ReplaceAllString(value, "")
+	value = strings.Replace(value, "Sept ", "Sep ", 1)
+
+	// Swap a trailing zone name for its offset
+	lastSpace := strings.LastIndex(value, " ")
+	if lastSpace > 0 {
+		if offset, ok := namedZoneOffsets[strings.ToUpper(value[lastSpace+1:])]; ok {
+			value = value[:lastSpace+1] + offset
+		}
+	}
+
+	return value
+}
+
+// Dates without a zone are assumed to be UTC
+func parsePublishDate(raw string) (time.Time, error) {
+	value := normalisePublishDate(raw)
+
+	if value == "" {
+		return time.Time{}, errors.New("no publish date")
+	}
+
+	for _, layout := range publishDateLayouts {
+		parsed, err := time.Parse(layout, value)
+		if err == nil {
+			return parsed, nil
+		}
+	}
+
+	return time.Time{}, errors.New("unrecognised publish date: " + raw)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePublishDate(t *testing.T) {
+	utc := func(month time.Month, day int, hour int, min int, sec int) time.Time {
+		return time.Date(2023, month, day, hour, min, sec, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		raw  string
+		want time.Time
+	}{
+		// RFC1123/RFC822
+		{"RFC1123 with offset", "Mon, 02 Jan 2023 15:04:05 +0000", utc(1, 2, 15, 4, 5)},
+		{"RFC1123 with other offset", "Mon, 02 Jan 2023 15:04:05 +1000", utc(1, 2, 5, 4, 5)},
+		{"RFC1123 with GMT", "Mon, 02 Jan 2023 15:04:05 GMT", utc(1, 2, 15, 4, 5)},
+		{"RFC1123 with US zone", "Mon, 02 Jan 2023 15:04:05 EST", utc(1, 2, 20, 4, 5)},
+		{"RFC1123 with zone in lower case", "Mon, 02 Jan 2023 15:04:05 pdt", utc(1, 2, 22, 4, 5)},
+		{"RFC1123 without zone", "Mon, 02 Jan 2023 15:04:05", utc(1, 2, 15, 4, 5)},
+		{"RFC1123 without weekday", "02 Jan 2023 15:04:05 +0000", utc(1, 2, 15, 4, 5)},
+		{"RFC1123 with colon in offset", "Mon, 02 Jan 2023 15:04:05 +00:00", utc(1, 2, 15, 4, 5)},
+		{"RFC822 without seconds", "Mon, 02 Jan 2023 15:04 +0000", utc(1, 2, 15, 4, 0)},
+		{"RFC822 two digit year", "Mon, 02 Jan 23 15:04:05 +0000", utc(1, 2, 15, 4, 5)},
+		// Malformed but common
+		{"misspelt weekday", "Thurs, 05 Jan 2023 15:04:05 GMT", utc(1, 5, 15, 4, 5)},
+		{"wrong weekday", "Fri, 02 Jan 2023 15:04:05 GMT", utc(1, 2, 15, 4, 5)},
+		{"weekday without comma", "Mon 02 Jan 2023 15:04:05 GMT", utc(1, 2, 15, 4, 5)},
+		{"single digit day", "Mon, 2 Jan 2023 15:04:05 GMT", utc(1, 2, 15, 4, 5)},
+		{"extra whitespace", "  Mon,  02 Jan  2023 15:04:05   GMT ", utc(1, 2, 15, 4, 5)},
+		{"Sept", "Sat, 02 Sept 2023 15:04:05 GMT", utc(9, 2, 15, 4, 5)},
+		{"full month name", "02 January 2023 15:04:05 +0000", utc(1, 2, 15, 4, 5)},
+		{"month first", "Jan 2, 2023 15:04:05 +0000", utc(1, 2, 15, 4, 5)},
+		{"ctime", "Mon Jan 2 15:04:05 2023", utc(1, 2, 15, 4, 5)},
+		{"date only", "02 Jan 2023", utc(1, 2, 0, 0, 0)},
+		{"long date only", "January 2, 2023", utc(1, 2, 0, 0, 0)},
+		// RFC3339/ISO 8601
+		{"RFC3339", "2023-01-02T15:04:05Z", utc(1, 2, 15, 4, 5)},
+		{"RFC3339 with offset", "2023-01-02T15:04:05+10:00", utc(1, 2, 5, 4, 5)},
+		{"RFC3339 with fraction", "2023-01-02T15:04:05.123Z", utc(1, 2, 15, 4, 5).Add(123 * time.Millisecond)},
+		{"ISO 8601 without seconds", "2023-01-02T15:04Z", utc(1, 2, 15, 4, 0)},
+		{"ISO 8601 without zone", "2023-01-02T15:04:05", utc(1, 2, 15, 4, 5)},
+		{"ISO 8601 offset without colon", "2023-01-02T15:04:05+0000", utc(1, 2, 15, 4, 5)},
+		{"space separated", "2023-01-02 15:04:05", utc(1, 2, 15, 4, 5)},
+		{"space separated with offset", "2023-01-02 15:04:05 +0000", utc(1, 2, 15, 4, 5)},
+		{"ISO date only", "2023-01-02", utc(1, 2, 0, 0, 0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parsePublishDate(test.raw)
+			if err != nil {
+				t.Fatalf("parsePublishDate(%q) failed: %v", test.raw, err)
+			}
+
+			if !got.Equal(test.want) {
+				t.Errorf("parsePublishDate(%q) = %v, want %v", test.raw, got, test.want)
+			}
+		})
+	}
+}
+
+// Callers fall back to the fetch time on an error
+func TestParsePublishDateUnparseable(t *testing.T) {
+	for _, raw := range []string{"", "   ", "yesterday", "32 Jan 2023 15:04:05 GMT", "2023-13-02", "Mon, 02 Foo 2023"} {
+		t.Run(raw, func(t *testing.T) {
+			if got, err := parsePublishDate(raw); err == nil {
+				t.Errorf("parsePublishDate(%q) = %v, want an error", raw, got)
+			}
+		})
+	}
+}
+
+func TestCreatePostParamsDateFallback(t *testing.T) {
+	fetchedAt := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	params, err := createPostParams(rssItem{Title: "A", Link: "https://example.com/a", PubDate: "yesterday"}, fetchedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !params.PublishedAt.Equal(fetchedAt) {
+		t.Errorf("got published at %v, want the fetch time %v", params.PublishedAt, fetchedAt)
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+	"github.com/google/uuid"
+)
+
+// Posts are matched within a feed by GUID first, then by canonical URL.
+// Publishers fiddle with tracking parameters all the time, so the URL used
+// for matching has those stripped
+
+const trackingParamPrefix = "utm_"
+const linklessKeyPrefix = "nolink:"
+
+// Lowercases scheme and host, drops default ports, fragments, utm_ parameters
+// and trailing slashes. Anything unparseable is compared as-is
+func canonicalUrl(raw string) string {
+	raw = strings.TrimSpace(raw)
+	parsed, err := url.Parse(raw)
+
+	if err != nil || parsed.Host == "" {
+		return raw
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+	if (parsed.Scheme == "http" && parsed.Port() == "80") || (parsed.Scheme == "https" && parsed.Port() == "443") {
+		parsed.Host = parsed.Hostname()
+	}
+
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+
+	query := parsed.Query()
+	for key := range query {
+		if strings.HasPrefix(strings.ToLower(key), trackingParamPrefix) {
+			query.Del(key)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+
+	parsed.Path = strings.TrimRight(parsed.Path, "/")
+	parsed.RawPath = ""
+
+	return parsed.String()
+}
+
+// Items without a link are matched on their title and date instead, kept in
+// the same column with a prefix real links don't use. The raw date is used,
+// since a missing one falls back to the fetch time. Empty if there's neither
+func postMatchKey(post rssItem) string {
+	if link := canonicalUrl(post.Link); link != "" {
+		return link
+	}
+
+	title, pubDate := strings.TrimSpace(post.Title), strings.TrimSpace(post.PubDate)
+	if title == "" && pubDate == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(title + "\n" + pubDate))
+	return linklessKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// GUID match wins. Otherwise the link decides, unless both sides have
+// GUIDs that differ - some feeds point every item at the same page
+func matchExistingPost(existing []database.Post, params postParams) (database.Post, bool) {
+	if params.Guid.Valid {
+		for _, post := range existing {
+			if post.Guid.Valid && post.Guid.String == params.Guid.String {
+				return post, true
+			}
+		}
+	}
+
+	// Without a link, title or date there's nothing else to go on
+	if params.CanonicalUrl == "" {
+		return database.Post{}, false
+	}
+
+	for _, post := range existing {
+		if post.CanonicalUrl != params.CanonicalUrl {
+			continue
+		}
+
+		if params.Guid.Valid && post.Guid.Valid {
+			continue
+		}
+
+		return post, true
+	}
+
+	return database.Post{}, false
+}
+
+// Writes every post in one statement. Posts seen before keep their ID so the
+// upsert updates them in place. Returns the rows that were created or changed
+func upsertPosts(ctx context.Context, queries *database.Queries, feedId uuid.UUID, fetchedAt time.Time, posts []postParams) ([]database.UpsertPostsRow, error) {
+	lookup := database.GetExistingPostsParams{
+		FeedID: feedId,
+	}
+
+	for _, params := range posts {
+		if params.Guid.Valid {
+			lookup.Guids = append(lookup.Guids, params.Guid.String)
+		}
+		lookup.CanonicalUrls = append(lookup.CanonicalUrls, params.CanonicalUrl)
+	}
+
+	existing, err := queries.GetExistingPosts(ctx, lookup)
+
+	if err != nil {
+		return nil, err
+	}
+
+	batch := database.UpsertPostsParams{
+		// Column has no time zone, so keep everything in UTC
+		FetchedAt: fetchedAt.UTC(),
+		FeedID:    feedId,
+	}
+	seenIds := map[uuid.UUID]bool{}
+	seenGuids := map[string]bool{}
+	// Canonical URLs in the batch, and whether any of them came without a
+	// GUID. Same rules as matchExistingPost
+	seenUrls := map[string]bool{}
+	seenUrlsWithoutGuid := map[string]bool{}
+
+	for _, params := range posts {
+		if post, ok := matchExistingPost(existing, params); ok {
+			params.ID = post.ID
+		}
+
+		// A post can't be written twice in one statement, and the same post
+		// twice in one feed would otherwise be stored twice
+		duplicateUrl := params.CanonicalUrl != "" && seenUrls[params.CanonicalUrl] &&
+			(!params.Guid.Valid || seenUrlsWithoutGuid[params.CanonicalUrl])
+
+		if seenIds[params.ID] || (params.Guid.Valid && seenGuids[params.Guid.String]) || duplicateUrl {
+			log.Printf("Post appears twice in feed: %v", params.Title)
+			continue
+		}
+		seenIds[params.ID] = true
+		if params.Guid.Valid {
+			seenGuids[params.Guid.String] = true
+		}
+		if params.CanonicalUrl != "" {
+			seenUrls[params.CanonicalUrl] = true
+			if !params.Guid.Valid {
+				seenUrlsWithoutGuid[params.CanonicalUrl] = true
+			}
+		}
+
+		batch.Ids = append(batch.Ids, params.ID)
+		batch.Titles = append(batch.Titles, params.Title)
+		batch.Urls = append(batch.Urls, params.Url)
+		batch.Descriptions = append(batch.Descriptions, params.Description.String)
+		batch.PublishedAts = append(batch.PublishedAts, params.PublishedAt)
+		batch.Contents = append(batch.Contents, params.Content.String)
+		batch.ContentTypes = append(batch.ContentTypes, params.ContentType.String)
+		batch.Excerpts = append(batch.Excerpts, params.Excerpt.String)
+		batch.Guids = append(batch.Guids, params.Guid.String)
+		batch.CanonicalUrls = append(batch.CanonicalUrls, params.CanonicalUrl)
+	}
+
+	if len(batch.Ids) == 0 {
+		return nil, nil
+	}
+
+	return queries.UpsertPosts(ctx, batch)
+}
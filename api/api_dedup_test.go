@@ -0,0 +1,121 @@
+package api
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestCanonicalUrl(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"unchanged", "https://example.com/posts/1", "https://example.com/posts/1"},
+		{"trailing slash", "https://example.com/posts/1/", "https://example.com/posts/1"},
+		{"root", "https://example.com/", "https://example.com"},
+		{"scheme and host case", "HTTPS://Example.COM/Posts/1", "https://example.com/Posts/1"},
+		{"default http port", "http://example.com:80/a", "http://example.com/a"},
+		{"default https port", "https://example.com:443/a", "https://example.com/a"},
+		{"other port kept", "https://example.com:8443/a", "https://example.com:8443/a"},
+		{"http port on https kept", "https://example.com:80/a", "https://example.com:80/a"},
+		{"fragment", "https://example.com/a#comments", "https://example.com/a"},
+		{"utm params", "https://example.com/a?utm_source=rss&utm_Medium=feed", "https://example.com/a"},
+		{"utm params any case", "https://example.com/a?UTM_source=rss", "https://example.com/a"},
+		{"other params kept and sorted", "https://example.com/a?utm_source=rss&page=2&id=7", "https://example.com/a?id=7&page=2"},
+		{"surrounding space", "  https://example.com/a  ", "https://example.com/a"},
+		{"no host", "/posts/1", "/posts/1"},
+		{"empty", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := canonicalUrl(test.raw); got != test.want {
+				t.Errorf("canonicalUrl(%q) = %q, want %q", test.raw, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPostMatchKey(t *testing.T) {
+	withLink := postMatchKey(rssItem{Title: "A", Link: "https://example.com/a/"})
+	if withLink != "https://example.com/a" {
+		t.Errorf("item with link got key %q, want its canonical URL", withLink)
+	}
+
+	first := postMatchKey(rssItem{Title: "A", PubDate: "Mon, 02 Jan 2006 15:04:05 GMT"})
+	again := postMatchKey(rssItem{Title: " A ", PubDate: "Mon, 02 Jan 2006 15:04:05 GMT"})
+	other := postMatchKey(rssItem{Title: "B", PubDate: "Mon, 02 Jan 2006 15:04:05 GMT"})
+
+	if !strings.HasPrefix(first, linklessKeyPrefix) {
+		t.Errorf("linkless item got key %q, want prefix %q", first, linklessKeyPrefix)
+	}
+
+	if first != again {
+		t.Errorf("same linkless item got keys %q and %q", first, again)
+	}
+
+	if first == other {
+		t.Errorf("different linkless items share key %q", first)
+	}
+
+	if key := postMatchKey(rssItem{Description: "Only a description"}); key != "" {
+		t.Errorf("item with nothing to match on got key %q, want none", key)
+	}
+}
+
+func testPost(guid string, canonicalUrl string) database.Post {
+	return database.Post{
+		ID:           uuid.New(),
+		Guid:         sql.NullString{String: guid, Valid: guid != ""},
+		CanonicalUrl: canonicalUrl,
+	}
+}
+
+func TestMatchExistingPost(t *testing.T) {
+	byGuid := testPost("guid-1", "https://example.com/a")
+	noGuid := testPost("", "https://example.com/b")
+	sharedPage := testPost("guid-2", "https://example.com/shared")
+	existing := []database.Post{byGuid, noGuid, sharedPage}
+
+	tests := []struct {
+		name         string
+		guid         string
+		canonicalUrl string
+		want         *database.Post
+	}{
+		{"same GUID", "guid-1", "https://example.com/a", &byGuid},
+		{"GUID wins over a different link", "guid-1", "https://example.com/moved", &byGuid},
+		{"GUID wins over another post's link", "guid-1", "https://example.com/b", &byGuid},
+		{"link without GUIDs", "", "https://example.com/b", &noGuid},
+		{"link when only the new item has a GUID", "guid-3", "https://example.com/b", &noGuid},
+		{"link when only the stored post has a GUID", "", "https://example.com/shared", &sharedPage},
+		{"same link with different GUIDs", "guid-3", "https://example.com/shared", nil},
+		{"unknown link", "", "https://example.com/new", nil},
+		{"no GUID or link", "", "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			post, ok := matchExistingPost(existing, postParams{
+				Guid:         sql.NullString{String: test.guid, Valid: test.guid != ""},
+				CanonicalUrl: test.canonicalUrl,
+			})
+
+			if test.want == nil {
+				if ok {
+					t.Errorf("matched %v, want no match", post.ID)
+				}
+				return
+			}
+
+			if !ok || post.ID != test.want.ID {
+				t.Errorf("got %v (matched %v), want %v", post.ID, ok, test.want.ID)
+			}
+		})
+	}
+}
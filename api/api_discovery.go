@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// People paste a blog's homepage far more often than its feed URL.
+// Discovery takes any page and works out which feed(s) it's talking about
+
+const discoveryTimeout = 15 * time.Second
+const maxDocumentBytes = 5 << 20
+
+// Tried in order against the site root when the page doesn't advertise a feed
+var commonFeedPaths = []string{
+	"/feed",
+	"/feed.xml",
+	"/index.xml",
+	"/rss",
+	"/rss.xml",
+	"/atom.xml",
+	"/feed.json",
+}
+
+var feedMediaTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/rdf+xml":   true,
+	"application/atom+xml":  true,
+	"application/feed+json": true,
+	"application/json":      true,
+}
+
+type feedCandidate struct {
+	Url   string `json:"url"`
+	Title string `json:"title"`
+	Type  string `json:"type"`
+	// Set when discovery already fetched and parsed the feed
+	feed *rss
+}
+
+type feedCandidatesResponse struct {
+	Candidates []feedCandidate `json:"candidates"`
+}
+
+type fetchedDocument struct {
+	Url         *url.URL
+	ContentType string
+	Body        []byte
+}
+
+// Reads one byte past the limit, so anything bigger is refused rather than cut short
+func readLimited(body io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("response is larger than %d bytes", limit)
+	}
+
+	return data, nil
+}
+
+func fetchDocument(ctx context.Context, rawUrl string) (*fetchedDocument, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := feedClient.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected response status: %v", resp.Status)
+	}
+
+	body, err := readLimited(resp.Body, maxDocumentBytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Relative links resolve against wherever any redirects ended up
+	return &fetchedDocument{
+		Url:         resp.Request.URL,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        body,
+	}, nil
+}
+
+func hasRel(rel string, value string) bool {
+	for _, field := range strings.Fields(strings.ToLower(rel)) {
+		if field == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Finds <link rel="alternate" type="application/rss+xml" href="..."> and friends
+func findFeedLinks(body []byte, base *url.URL) []feedCandidate {
+	var candidates []feedCandidate
+	seen := map[string]bool{}
+	tokenizer := nethtml.NewTokenizer(bytes.NewReader(body))
+
+	for {
+		tokenType := tokenizer.Next()
+
+		switch tokenType {
+		case nethtml.ErrorToken:
+			return candidates
+		case nethtml.EndTagToken:
+			// Feed links belong in the head
+			if tokenizer.Token().DataAtom == atom.Head {
+				return candidates
+			}
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.DataAtom == atom.Body {
+				return candidates
+			}
+			if token.DataAtom != atom.Link {
+				continue
+			}
+
+			var rel, linkType, href, title string
+			for _, attribute := range token.Attr {
+				switch strings.ToLower(attribute.Key) {
+				case "rel":
+					rel = attribute.Val
+				case "type":
+					linkType = attribute.Val
+				case "href":
+					href = attribute.Val
+				case "title":
+					title = attribute.Val
+				}
+			}
+
+			mediaType, _, _ := mime.ParseMediaType(linkType)
+			if !hasRel(rel, "alternate") || !feedMediaTypes[mediaType] || href == "" {
+				continue
+			}
+
+			resolved, err := base.Parse(strings.TrimSpace(href))
+			if err != nil || seen[resolved.String()] {
+				continue
+			}
+
+			seen[resolved.String()] = true
+			candidates = append(candidates, feedCandidate{
+				Url:   resolved.String(),
+				Title: strings.TrimSpace(title),
+				Type:  mediaType,
+			})
+		}
+	}
+}
+
+// Links found in a page are only a claim - this checks there's really a feed there
+func loadFeed(ctx context.Context, feedUrl string) (*rss, error) {
+	document, err := fetchDocument(ctx, feedUrl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFeedDocument(document)
+}
+
+func parseFeedDocument(document *fetchedDocument) (*rss, error) {
+	feed, err := parseFeed(document.ContentType, document.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(feed.Channels) == 0 {
+		return nil, errors.New("feed has no channel")
+	}
+
+	return feed, nil
+}
+
+func feedTitle(feed *rss) string {
+	if feed == nil || len(feed.Channels) == 0 {
+		return ""
+	}
+
+	return strings.TrimSpace(feed.Channels[0].Title)
+}
+
+// Stops at the first path that turns out to be a feed - sites that serve
+// more than one usually serve the same posts in each
+func probeCommonFeedPaths(ctx context.Context, base *url.URL) []feedCandidate {
+	for _, path := range commonFeedPaths {
+		probeUrl := &url.URL{Scheme: base.Scheme, Host: base.Host, Path: path}
+		document, err := fetchDocument(ctx, probeUrl.String())
+
+		if err != nil {
+			continue
+		}
+
+		feed, err := parseFeedDocument(document)
+		if err != nil {
+			continue
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(document.ContentType)
+		return []feedCandidate{{
+			Url:   probeUrl.String(),
+			Title: feedTitle(feed),
+			Type:  mediaType,
+			feed:  feed,
+		}}
+	}
+
+	return nil
+}
+
+// Returns a single candidate when pageUrl is a feed itself, otherwise
+// whatever feeds the page links to or the site serves at the usual paths
+func discoverFeeds(ctx context.Context, pageUrl string) ([]feedCandidate, error) {
+	document, err := fetchDocument(ctx, pageUrl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	feed, err := parseFeedDocument(document)
+	if err == nil {
+		mediaType, _, _ := mime.ParseMediaType(document.ContentType)
+		return []feedCandidate{{
+			Url:   pageUrl,
+			Title: feedTitle(feed),
+			Type:  mediaType,
+			feed:  feed,
+		}}, nil
+	}
+
+	candidates := findFeedLinks(document.Body, document.Url)
+	if len(candidates) > 0 {
+		return candidates, nil
+	}
+
+	return probeCommonFeedPaths(ctx, document.Url), nil
+}
+
+// Everything a new feed goes through before it's stored, whether it's added
+// directly or imported. Anything added has been fetched and parsed at least
+// once. When the page offers more than one feed they're all returned instead,
+// for the caller to pick from
+func discoverNewFeed(ctx context.Context, rawUrl string) (feedCandidate, []feedCandidate, error) {
+	candidates, err := discoverFeeds(ctx, rawUrl)
+
+	// Details stay in the log. Passing them on would tell the caller what
+	// answers at any address they care to try
+	if err != nil {
+		log.Printf("Error discovering feeds at %v: %v", rawUrl, err)
+		return feedCandidate{}, nil, errors.New("could not fetch url")
+	}
+
+	if len(candidates) == 0 {
+		return feedCandidate{}, nil, errors.New("no feeds found at url")
+	}
+
+	if len(candidates) > 1 {
+		return feedCandidate{}, candidates, nil
+	}
+
+	candidate := candidates[0]
+	if err := validateFeedUrl(candidate.Url); err != nil {
+		return feedCandidate{}, nil, fmt.Errorf("discovered feed %v", err)
+	}
+
+	if candidate.feed == nil {
+		candidate.feed, err = loadFeed(ctx, candidate.Url)
+
+		if err != nil {
+			log.Printf("Error loading discovered feed %v: %v", candidate.Url, err)
+			return feedCandidate{}, nil, errors.New("url is not a valid feed")
+		}
+	}
+
+	return candidate, nil, nil
+}
@@ -2,9 +2,12 @@ package api
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/ajpotts01/go-blog-aggregator/internal/database"
@@ -22,13 +25,22 @@ type followFeedRequest struct {
 }
 
 type feedResponse struct {
-	Id            uuid.UUID  `json:"id"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
-	LastFetchedAt *time.Time `json:"last_fetched_at"`
-	Name          string     `json:"name"`
-	Url           string     `json:"url"`
-	UserId        uuid.UUID  `json:"user_id"`
+	Id                  uuid.UUID  `json:"id"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	LastFetchedAt       *time.Time `json:"last_fetched_at"`
+	Name                string     `json:"name"`
+	Url                 string     `json:"url"`
+	UserId              uuid.UUID  `json:"user_id"`
+	LastError           *string    `json:"last_error"`
+	LastStatus          *int32     `json:"last_status"`
+	ConsecutiveFailures int32      `json:"consecutive_failures"`
+	NextFetchAt         *time.Time `json:"next_fetch_at"`
+	FetchInterval       *int32     `json:"fetch_interval_seconds"`
+	SiteUrl             *string    `json:"site_url"`
+	Description         *string    `json:"description"`
+	Language            *string    `json:"language"`
+	ImageUrl            *string    `json:"image_url"`
 }
 
 type followResponse struct {
@@ -37,6 +49,7 @@ type followResponse struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	UserId    uuid.UUID `json:"user_id"`
 	FeedId    uuid.UUID `json:"feed_id"`
+	Folder    *string   `json:"folder"`
 }
 
 type newFeedResponse struct {
@@ -48,31 +61,61 @@ type feedList struct {
 	Feeds []feedResponse `json:"feeds"`
 }
 
-type postResponse struct {
-	Id          uuid.UUID `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Title       string    `json:"title"`
-	Url         string    `json:"url"`
-	Description string    `json:"description"`
-	PublishedAt time.Time `json:"published_at"`
-	FeedID      uuid.UUID `json:"feed_id"`
-	FeedName    string    `json:"feed_name"`
-	FeedUrl     string    `json:"feed_url"`
+// Nulls come back as JSON nulls rather than zero values
+func nullTimeResponse(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+
+	return &value.Time
+}
+
+func nullStringResponse(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+
+	return &value.String
+}
+
+func nullInt32Response(value sql.NullInt32) *int32 {
+	if !value.Valid {
+		return nil
+	}
+
+	return &value.Int32
 }
 
 func mapFeedResponse(feed database.Feed) feedResponse {
 	return feedResponse{
-		Id:            feed.ID,
-		UserId:        feed.UserID,
-		CreatedAt:     feed.CreatedAt,
-		UpdatedAt:     feed.UpdatedAt,
-		LastFetchedAt: &feed.LastFetchedAt.Time,
-		Name:          feed.Name,
-		Url:           feed.Url,
+		Id:                  feed.ID,
+		UserId:              feed.UserID,
+		CreatedAt:           feed.CreatedAt,
+		UpdatedAt:           feed.UpdatedAt,
+		LastFetchedAt:       nullTimeResponse(feed.LastFetchedAt),
+		Name:                feed.Name,
+		Url:                 feed.Url,
+		LastError:           nullStringResponse(feed.LastError),
+		LastStatus:          nullInt32Response(feed.LastStatus),
+		ConsecutiveFailures: feed.ConsecutiveFailures,
+		NextFetchAt:         nullTimeResponse(feed.NextFetchAt),
+		FetchInterval:       nullInt32Response(feed.FetchIntervalSeconds),
+		SiteUrl:             nullStringResponse(feed.SiteUrl),
+		Description:         nullStringResponse(feed.Description),
+		Language:            nullStringResponse(feed.Language),
+		ImageUrl:            nullStringResponse(feed.ImageUrl),
 	}
 }
 
+// Anyone can list feeds, and the last error can hold whatever the upstream
+// server sent back - so it's only shown to the user adding the feed
+func mapPublicFeedResponse(feed database.Feed) feedResponse {
+	response := mapFeedResponse(feed)
+	response.LastError = nil
+
+	return response
+}
+
 func mapFollowResponse(follow database.Follow) followResponse {
 	return followResponse{
 		Id:        follow.ID,
@@ -80,6 +123,7 @@ func mapFollowResponse(follow database.Follow) followResponse {
 		FeedId:    follow.FeedID,
 		CreatedAt: follow.CreatedAt,
 		UpdatedAt: follow.UpdatedAt,
+		Folder:    nullStringResponse(follow.Folder),
 	}
 }
 
@@ -90,15 +134,6 @@ func createNewFeedResponse(feed database.Feed, follow database.Follow) newFeedRe
 	}
 }
 
-func getPostByUserParams(userId uuid.UUID) database.GetPostsByUserParams {
-	params := database.GetPostsByUserParams{
-		UserID: userId,
-		Limit:  5,
-	}
-
-	return params
-}
-
 func createFollowParams(userId uuid.UUID, feedId uuid.UUID) (database.CreateFollowParams, error) {
 	newId, err := uuid.NewUUID()
 
@@ -140,6 +175,24 @@ func createFeedParams(name string, url string, userId uuid.UUID) (database.Creat
 	return params, nil
 }
 
+// Channel details shown alongside the feed. URLs are resolved against the
+// feed and dropped unless they're http(s)
+func setFeedMetadata(params *database.CreateFeedParams, feed *rss) {
+	channel := feed.Channels[0]
+	base, _ := url.Parse(params.Url)
+
+	if siteUrl, ok := sanitiseUrl(channel.Link, base, false); ok && channel.Link != "" {
+		params.SiteUrl = nullableString(siteUrl)
+	}
+
+	if imageUrl, ok := sanitiseUrl(channel.Image.Url, base, false); ok && channel.Image.Url != "" {
+		params.ImageUrl = nullableString(imageUrl)
+	}
+
+	params.Description = nullableString(htmlToText(channel.Description))
+	params.Language = nullableString(strings.TrimSpace(channel.Language))
+}
+
 func unfollowParams(followId uuid.UUID, userId uuid.UUID) database.DeleteFollowParams {
 	params := database.DeleteFollowParams{
 		ID:     followId,
@@ -162,7 +215,40 @@ func (config *ApiConfig) CreateFeed(w http.ResponseWriter, r *http.Request, user
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	dbFeedParams, err := createFeedParams(requestParams.Name, requestParams.Url, user.ID)
+
+	if err := validateFeedUrl(requestParams.Url); err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The URL can be a feed or any page that points at one
+	ctx, cancel := context.WithTimeout(r.Context(), discoveryTimeout)
+	defer cancel()
+
+	candidate, candidates, err := discoverNewFeed(ctx, requestParams.Url)
+
+	if err != nil {
+		errorResponse(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	// Let the client pick, then POST again with the chosen candidate's URL
+	if len(candidates) > 1 {
+		validResponse(w, http.StatusMultipleChoices, feedCandidatesResponse{Candidates: candidates})
+		return
+	}
+
+	feedUrl, feed := candidate.Url, candidate.feed
+
+	feedName := strings.TrimSpace(requestParams.Name)
+	if feedName == "" {
+		feedName = truncateFeedName(feedTitle(feed))
+	}
+	if feedName == "" {
+		feedName = truncateFeedName(feedUrl)
+	}
+
+	dbFeedParams, err := createFeedParams(feedName, feedUrl, user.ID)
 
 	if err != nil {
 		log.Printf("Error creating new feed params: %v", err)
@@ -170,6 +256,8 @@ func (config *ApiConfig) CreateFeed(w http.ResponseWriter, r *http.Request, user
 		return
 	}
 
+	setFeedMetadata(&dbFeedParams, feed)
+
 	newFeed, err := config.DbConn.CreateFeed(context.TODO(), dbFeedParams)
 
 	if err != nil {
@@ -213,7 +301,7 @@ func (config *ApiConfig) GetFeeds(w http.ResponseWriter, r *http.Request) {
 	var returnedFeeds []feedResponse
 
 	for _, feed := range feeds {
-		returnedFeeds = append(returnedFeeds, mapFeedResponse(feed))
+		returnedFeeds = append(returnedFeeds, mapPublicFeedResponse(feed))
 	}
 
 	validResponse(w, http.StatusOK, returnedFeeds)
@@ -299,36 +387,3 @@ func (config *ApiConfig) GetFollows(w http.ResponseWriter, r *http.Request, user
 	validResponse(w, http.StatusOK, returnedFollows)
 	return
 }
-
-// GET /api/posts
-func (config *ApiConfig) GetPostsForUser(w http.ResponseWriter, r *http.Request, user database.User) {
-	var returnedPosts []postResponse
-
-	w.Header().Set("Content-Type", "application/json")
-
-	params := getPostByUserParams(user.ID)
-	posts, err := config.DbConn.GetPostsByUser(context.TODO(), params)
-
-	if err != nil {
-		errorResponse(w, http.StatusInternalServerError, "Error retrieving posts")
-		return
-	}
-
-	for _, post := range posts {
-		returnedPosts = append(returnedPosts, postResponse{
-			Id:          post.ID,
-			CreatedAt:   post.CreatedAt,
-			UpdatedAt:   post.UpdatedAt,
-			Title:       post.Title,
-			Url:         post.Url,
-			Description: post.Description.String,
-			PublishedAt: post.PublishedAt.Time,
-			FeedID:      post.FeedID,
-			FeedName:    post.FeedName,
-			FeedUrl:     post.FeedUrl,
-		})
-	}
-
-	validResponse(w, http.StatusOK, returnedPosts)
-	return
-}
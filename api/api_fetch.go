@@ -1,18 +1,23 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/ajpotts01/go-blog-aggregator/internal/database"
 	"github.com/google/uuid"
-	"github.com/lib/pq"
+	"golang.org/x/net/html/charset"
 )
 
 // RSS feeds are always XML spec
@@ -25,167 +30,517 @@ import (
 	And any other blogs you enjoy that have RSS feeds.
 */
 
+const contentTypeHtml = "text/html"
+const contentTypeText = "text/plain"
+
 type rssItem struct {
 	Title       string `xml:"title"`
 	Link        string `xml:"link"`
 	PubDate     string `xml:"pubDate"`
 	Guid        string `xml:"guid"`
 	Description string `xml:"description"`
+	// Full article body from the content module, if the feed has it
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	ContentType string `xml:"-"`
+	// Plain text, filled in by sanitiseItem
+	Excerpt string `xml:"-"`
+}
+
+type rssImage struct {
+	Url   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
 }
 
 type rssChannel struct {
-	Title         string    `xml:"title"`
-	Link          string    `xml:"link"`
-	Description   string    `xml:"description"`
-	Generator     string    `xml:"generator"`
-	Language      string    `xml:"language"`
-	LastBuildDate string    `xml:"lastBuildDate"`
-	Items         []rssItem `xml:"item"`
-	//AtomLink      string    `xml:"xmlns atom,attr"`
-	// atom:link?
+	Title string `xml:"title"`
+	// Must come before Link - an unqualified tag matches any namespace, so
+	// an <atom:link rel="self"/> would otherwise wipe out the site link
+	AtomLinks     []atomLink `xml:"http://www.w3.org/2005/Atom link"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	Generator     string     `xml:"generator"`
+	Language      string     `xml:"language"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Image         rssImage   `xml:"image"`
+	// Polling hints - see api_polling.go
+	Ttl             string    `xml:"ttl"`
+	UpdatePeriod    string    `xml:"http://purl.org/rss/1.0/modules/syndication/ updatePeriod"`
+	UpdateFrequency string    `xml:"http://purl.org/rss/1.0/modules/syndication/ updateFrequency"`
+	Items           []rssItem `xml:"item"`
 }
 
 type rss struct {
 	Channels []rssChannel `xml:"channel"`
 }
 
-func createPostParams(post rssItem, feedId uuid.UUID) (database.CreatePostParams, error) {
+type feedFormat int
+
+const (
+	formatUnknown feedFormat = iota
+	formatRss
+	formatRdf
+	formatAtom
+	formatJsonFeed
+)
+
+func newXmlDecoder(input io.Reader) *xml.Decoder {
+	decoder := xml.NewDecoder(input)
+	// Plenty of feeds declare encodings like ISO-8859-1 or windows-1252.
+	// Ones we don't know are passed through rather than failing the feed
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		reader, err := charset.NewReaderLabel(label, input)
+
+		if err != nil {
+			return input, nil
+		}
+
+		return reader, nil
+	}
+
+	return decoder
+}
+
+// Trust the Content-Type for JSON Feed, otherwise sniff the document.
+// Only the root element is needed to tell RSS, RDF and Atom apart
+func detectFeedFormat(contentType string, rawData []byte) feedFormat {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/feed+json" || mediaType == "application/json" {
+		return formatJsonFeed
+	}
+
+	if trimmed := bytes.TrimSpace(rawData); len(trimmed) > 0 && trimmed[0] == '{' {
+		return formatJsonFeed
+	}
+
+	decoder := newXmlDecoder(bytes.NewReader(rawData))
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return formatUnknown
+		}
+
+		root, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case root.Name.Local == "feed" && root.Name.Space == atomNamespace:
+			return formatAtom
+		case root.Name.Local == "rss":
+			return formatRss
+		case root.Name.Local == "RDF" && root.Name.Space == rdfNamespace:
+			return formatRdf
+		default:
+			return formatUnknown
+		}
+	}
+}
+
+func parseFeed(contentType string, rawData []byte) (*rss, error) {
+	switch detectFeedFormat(contentType, rawData) {
+	// Decoded into values rather than pointers - a JSON body of null would
+	// otherwise leave a nil feed behind with no error
+	case formatRss:
+		feed := rss{}
+		err := newXmlDecoder(bytes.NewReader(rawData)).Decode(&feed)
+		if err != nil {
+			return nil, err
+		}
+		return &feed, nil
+	case formatRdf:
+		feed := rdfFeed{}
+		err := newXmlDecoder(bytes.NewReader(rawData)).Decode(&feed)
+		if err != nil {
+			return nil, err
+		}
+		return feed.toRss(), nil
+	case formatAtom:
+		feed := atomFeed{}
+		err := newXmlDecoder(bytes.NewReader(rawData)).Decode(&feed)
+		if err != nil {
+			return nil, err
+		}
+		return feed.toRss(), nil
+	case formatJsonFeed:
+		feed := jsonFeed{}
+		err := json.Unmarshal(rawData, &feed)
+		if err != nil {
+			return nil, err
+		}
+		if err = feed.validate(); err != nil {
+			return nil, err
+		}
+		return feed.toRss(), nil
+	default:
+		return nil, errors.New("unrecognised feed format")
+	}
+}
+
+// One post as upsertPosts writes it. The feed and timestamps are the same
+// for the whole batch, so they're given to upsertPosts instead
+type postParams struct {
+	ID           uuid.UUID
+	Title        string
+	Url          string
+	Description  sql.NullString
+	PublishedAt  time.Time
+	Content      sql.NullString
+	ContentType  sql.NullString
+	Excerpt      sql.NullString
+	Guid         sql.NullString
+	CanonicalUrl string
+}
+
+func createPostParams(post rssItem, fetchedAt time.Time) (postParams, error) {
 	newId, err := uuid.NewUUID()
 
 	if err != nil {
-		return database.CreatePostParams{}, err
+		return postParams{}, err
 	}
 
-	createdAt := time.Now()
-
-	// Times of all sampled feeds appear to conform to RFC1123Z standard
-	// this is despite the RSS spec saying RFC822...
-	// ...supported by examples that aren't RFC822. Nice one.
-	publishedAt, err := time.Parse(time.RFC1123Z, post.PubDate)
+	// A missing or mangled date shouldn't cost us the post
+	publishedAt, err := parsePublishDate(post.PubDate)
 
 	if err != nil {
-		return database.CreatePostParams{}, err
+		log.Printf("Using fetch time for %v: %v", post.Title, err)
+		publishedAt = fetchedAt
+	}
+
+	params := postParams{
+		ID:    newId,
+		Title: post.Title,
+		// Column has no time zone, so keep everything in UTC
+		PublishedAt:  publishedAt.UTC(),
+		Description:  nullableString(post.Description),
+		Excerpt:      nullableString(post.Excerpt),
+		Url:          post.Link,
+		Guid:         nullableString(strings.TrimSpace(post.Guid)),
+		CanonicalUrl: postMatchKey(post),
+	}
+
+	// It would be stored again on every fetch
+	if !params.Guid.Valid && params.CanonicalUrl == "" {
+		return postParams{}, errors.New("no GUID, link, title or date to tell it apart")
+	}
+
+	// Without a full body the description is all we've got
+	content, contentType := post.Content, post.ContentType
+	if content == "" {
+		content, contentType = post.Description, ""
+	}
+
+	// RSS never says, but it's HTML in practice
+	if contentType == "" {
+		contentType = contentTypeHtml
+	}
+
+	if content != "" {
+		params.Content = nullableString(content)
+		params.ContentType = nullableString(contentType)
+	}
+
+	return params, nil
+}
+
+// Failing feeds are retried after 1m, 2m, 4m... up to once a day
+const fetchBackoffBase = time.Minute
+const fetchBackoffMax = 24 * time.Hour
+
+type fetchResult struct {
+	Feed         *rss
+	StatusCode   int
+	NotModified  bool
+	ETag         string
+	LastModified string
+	// From Cache-Control and Retry-After, zero if not given
+	MaxAge     time.Duration
+	RetryAfter time.Duration
+}
+
+func nullableString(value string) sql.NullString {
+	return sql.NullString{
+		String: value,
+		Valid:  value != "",
+	}
+}
+
+// No status means the request never got a response
+func nullableStatus(statusCode int) sql.NullInt32 {
+	return sql.NullInt32{
+		Int32: int32(statusCode),
+		Valid: statusCode != 0,
 	}
+}
 
-	params := database.CreatePostParams{
-		ID:        newId,
-		CreatedAt: createdAt,
-		UpdatedAt: createdAt,
-		Title:     post.Title,
-		PublishedAt: sql.NullTime{
-			Time: publishedAt,
+func fetchBackoff(failures int32) time.Duration {
+	backoff := fetchBackoffBase
+
+	for i := int32(1); i < failures && backoff < fetchBackoffMax; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, fetchBackoffMax)
+}
+
+// TIMESTAMP columns hold UTC whatever the database's TimeZone is: times are
+// written from Go in UTC, and the queries use now() AT TIME ZONE 'UTC'
+func markFeedFetchedParams(feed database.Feed, result *fetchResult) database.MarkFeedFetchedParams {
+	interval := pollInterval(feed, result)
+
+	params := database.MarkFeedFetchedParams{
+		ID:           feed.ID,
+		Etag:         nullableString(result.ETag),
+		LastModified: nullableString(result.LastModified),
+		LastStatus:   nullableStatus(result.StatusCode),
+		NextFetchAt: sql.NullTime{
+			Time:  time.Now().UTC().Add(interval),
+			Valid: true,
 		},
-		Description: sql.NullString{
-			String: post.Description,
+		FetchIntervalSeconds: sql.NullInt32{
+			Int32: int32(interval.Seconds()),
+			Valid: true,
 		},
-		FeedID: feedId,
-		Url:    post.Link,
+		LockedUntil: feed.LockedUntil,
 	}
 
-	return params, nil
+	return params
 }
 
-func (config *ApiConfig) fetchFeed(url string) (*rss, error) {
+func markFeedFetchFailedParams(feed database.Feed, result *fetchResult, fetchErr error) database.MarkFeedFetchFailedParams {
+	// A server asking us to back off for longer gets its way
+	backoff := max(fetchBackoff(feed.ConsecutiveFailures+1), result.RetryAfter)
+	nextFetchAt := time.Now().UTC().Add(backoff)
+
+	params := database.MarkFeedFetchFailedParams{
+		ID:         feed.ID,
+		LastError:  nullableString(fetchErr.Error()),
+		LastStatus: nullableStatus(result.StatusCode),
+		NextFetchAt: sql.NullTime{
+			Time:  nextFetchAt,
+			Valid: true,
+		},
+		LockedUntil: feed.LockedUntil,
+	}
+
+	return params
+}
+
+// Sends the validators from the last fetch so unchanged feeds come back as a 304
+func (config *ApiConfig) fetchFeed(ctx context.Context, feed database.Feed) (*fetchResult, error) {
 	var rawData []byte
-	var feed *rss
+	result := &fetchResult{
+		ETag:         feed.Etag.String,
+		LastModified: feed.LastModified.String,
+	}
+
+	log.Printf("Reading from %v", feed.Url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.Url, nil)
+
+	if err != nil {
+		log.Printf("Error creating feed request: %v", err)
+		return result, err
+	}
+
+	if result.ETag != "" {
+		req.Header.Set("If-None-Match", result.ETag)
+	}
+
+	if result.LastModified != "" {
+		req.Header.Set("If-Modified-Since", result.LastModified)
+	}
 
-	log.Printf("Reading from %v", url)
-	resp, err := http.Get(url)
+	resp, err := feedClient.Do(req)
 
 	if err != nil {
 		log.Printf("Error getting feed: %v", err)
-		return feed, err
+		return result, err
 	}
 
+	defer resp.Body.Close()
+
 	//log.Printf("Raw response: %v", resp.Body)
 	log.Printf("Response code: %v", resp.StatusCode)
-	rawData, err = io.ReadAll(resp.Body)
+	result.StatusCode = resp.StatusCode
+
+	// Servers should repeat the validators on a 304, but keep the old ones if not
+	if eTag := resp.Header.Get("ETag"); eTag != "" {
+		result.ETag = eTag
+	}
+
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		result.LastModified = lastModified
+	}
+
+	result.MaxAge = cacheMaxAge(resp.Header.Get("Cache-Control"))
+	result.RetryAfter = retryAfter(resp.Header.Get("Retry-After"), time.Now())
+
+	if resp.StatusCode == http.StatusNotModified {
+		result.NotModified = true
+		return result, nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return result, fmt.Errorf("unexpected response status: %v", resp.Status)
+	}
+
+	rawData, err = readLimited(resp.Body, maxDocumentBytes)
 	log.Printf("Bytes read: %v", len(rawData))
-	defer resp.Body.Close()
 
 	if err != nil {
 		log.Printf("Error reading feed response: %v", err)
-		return feed, err
+		return result, err
 	}
 
-	err = xml.Unmarshal(rawData, &feed)
+	result.Feed, err = parseFeed(resp.Header.Get("Content-Type"), rawData)
 	if err != nil {
-		log.Printf("Error unmarshalling XML: %v", err)
-		return feed, err
+		log.Printf("Error parsing feed: %v", err)
+		return result, err
 	}
 
 	// test := string(rawData[:])
 	// log.Printf(test)
-	return feed, nil
+	return result, nil
 }
 
-func (config *ApiConfig) processFeed(feed *rss, feedId uuid.UUID) error {
+// What happened to a feed's items on one fetch
+type ingestSummary struct {
+	ItemsSeen    int
+	PostsCreated int
+	PostsUpdated int
+	// Items stored with a best guess, or skipped
+	ParseErrors []string
+}
+
+// Sanitises every item and writes them all with queries, which may be bound
+// to a transaction. Items that can't be turned into posts are skipped
+func processFeed(ctx context.Context, queries *database.Queries, feed *rss, source database.Feed) (ingestSummary, error) {
+	fetchedAt := time.Now()
+	summary := ingestSummary{}
+	var posts []postParams
+
 	for _, c := range feed.Channels {
 		for _, item := range c.Items {
-			params, err := createPostParams(item, feedId)
+			summary.ItemsSeen++
 
-			if err != nil {
-				return err
+			// createPostParams falls back to the fetch time, but it's worth reporting
+			if _, err := parsePublishDate(item.PubDate); err != nil {
+				summary.ParseErrors = append(summary.ParseErrors, fmt.Sprintf("%v: %v", item.Title, err))
 			}
 
-			post, err := config.DbConn.CreatePost(context.TODO(), params)
+			link := item.Link
+			item = sanitiseItem(item, postBaseUrl(item, c, source.Url))
+
+			if link != "" && item.Link == "" {
+				summary.ParseErrors = append(summary.ParseErrors, fmt.Sprintf("%v: unsafe link %q dropped", item.Title, link))
+			}
+
+			params, err := createPostParams(item, fetchedAt)
+
 			if err != nil {
-				if postgresErr, ok := err.(*pq.Error); ok {
-					if postgresErr.Code == "23505" {
-						log.Printf("Post already exists: %v", item.Title)
-					} else {
-						return err
-					}
-				}
-			} else {
-				log.Printf("Created post: %v", post.Title)
+				log.Printf("Error creating post params for %v: %v", item.Title, err)
+				summary.ParseErrors = append(summary.ParseErrors, fmt.Sprintf("%v: %v", item.Title, err))
+				continue
 			}
+
+			posts = append(posts, params)
 		}
+	}
+
+	stored, err := upsertPosts(ctx, queries, source.ID, fetchedAt, posts)
 
-		log.Printf("Processed feed: %v", c.Title)
+	if err != nil {
+		return summary, err
 	}
 
-	return nil
+	for _, row := range stored {
+		if row.Inserted {
+			summary.PostsCreated++
+		} else {
+			summary.PostsUpdated++
+		}
+	}
+
+	log.Printf("Processed feed %v: %d new posts, %d updated", source.Url, summary.PostsCreated, summary.PostsUpdated)
+	return summary, nil
 }
 
-func (config *ApiConfig) FetchLoop() {
-	loopTimer := 60 * time.Second
-	ticker := time.NewTicker(loopTimer)
+// Posts and the fetch bookkeeping commit together, so a feed is never left
+// half-ingested with validators that would stop it being fetched again
+func (config *ApiConfig) storeFeed(ctx context.Context, feed database.Feed, result *fetchResult) (ingestSummary, error) {
+	tx, err := config.Db.BeginTx(ctx, nil)
 
-	log.Printf("Init fetch loop")
+	if err != nil {
+		return ingestSummary{}, err
+	}
 
-	for {
-		var urlPool sync.WaitGroup
-		// Block until a signal is received from the ticker
-		<-ticker.C
+	defer tx.Rollback()
+	queries := config.DbConn.WithTx(tx)
 
-		log.Printf("Fetch loop running...")
-		// Want to grab up to X feeds at once.
-		// This is configured in config.MaxNumProcessed
-		feeds, err := config.DbConn.GetNextFeedsToFetch(context.TODO(), int32(config.MaxFeedsProcessed))
+	summary, err := processFeed(ctx, queries, result.Feed, feed)
+	if err != nil {
+		return summary, err
+	}
 
-		if err != nil {
-			log.Printf("Error: failed to retrieve feeds to fetch: %v", err)
-			continue
-		}
+	err = queries.MarkFeedFetched(ctx, markFeedFetchedParams(feed, result))
+	if err != nil {
+		return summary, err
+	}
+
+	return summary, tx.Commit()
+}
 
-		log.Printf("Got %v feeds", len(feeds))
-
-		for _, feed := range feeds {
-			urlPool.Add(1)
-			go func(url string, id uuid.UUID) {
-				defer urlPool.Done()
-				log.Printf("Fetching from %s", url)
-				rss, err := config.fetchFeed(url)
-				config.DbConn.MarkFeedFetched(context.TODO(), id)
-				if err != nil {
-					log.Printf("Error: failed to retrieve items from feed %s: %v", url, err)
-					return
-				}
-				config.processFeed(rss, id)
-			}(feed.Url, feed.ID)
+// A failure to record the failure is only logged - the fetch error is what the caller reports
+func (config *ApiConfig) markFeedFetchFailed(ctx context.Context, feed database.Feed, result *fetchResult, fetchErr error) {
+	err := config.DbConn.MarkFeedFetchFailed(ctx, markFeedFetchFailedParams(feed, result, fetchErr))
+
+	if err != nil {
+		log.Printf("Error recording failed fetch of feed %s: %v", feed.Url, err)
+	}
+}
+
+type refreshOutcome struct {
+	Result  *fetchResult
+	Summary ingestSummary
+	Err     error
+}
+
+// Fetches a single feed and stores its posts, recording the outcome on the feed row.
+// The timeout only covers the HTTP request - database writes use ctx as-is
+func (config *ApiConfig) refreshFeed(ctx context.Context, feed database.Feed, timeout time.Duration) refreshOutcome {
+	log.Printf("Fetching from %s", feed.Url)
+	fetchCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := config.fetchFeed(fetchCtx, feed)
+	if err != nil {
+		// Validators from a response we couldn't use aren't kept
+		config.markFeedFetchFailed(ctx, feed, result, err)
+		log.Printf("Error: failed to retrieve items from feed %s: %v", feed.Url, err)
+		return refreshOutcome{Result: result, Err: err}
+	}
+
+	if result.NotModified {
+		markErr := config.DbConn.MarkFeedFetched(ctx, markFeedFetchedParams(feed, result))
+		if markErr != nil {
+			log.Printf("Error recording fetch of feed %s: %v", feed.Url, markErr)
 		}
-		log.Printf("Waiting for fetching to end...")
-		urlPool.Wait()
+
+		log.Printf("Feed not modified: %s", feed.Url)
+		return refreshOutcome{Result: result}
+	}
+
+	summary, err := config.storeFeed(ctx, feed, result)
+	if err != nil {
+		// Counts as a failed fetch so it's retried with backoff
+		config.markFeedFetchFailed(ctx, feed, result, err)
+		log.Printf("Error: failed to store posts from feed %s: %v", feed.Url, err)
+
+		// Nothing was committed
+		summary.PostsCreated, summary.PostsUpdated = 0, 0
 	}
+
+	return refreshOutcome{Result: result, Summary: summary, Err: err}
 }
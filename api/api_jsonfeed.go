@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"strings"
+)
+
+// JSON Feed 1.1
+// https://www.jsonfeed.org/version/1.1/
+
+const jsonFeedVersionPrefix = "https://jsonfeed.org/version/"
+
+type jsonFeedItem struct {
+	Id            string `json:"id"`
+	Url           string `json:"url"`
+	ExternalUrl   string `json:"external_url"`
+	Title         string `json:"title"`
+	ContentHtml   string `json:"content_html"`
+	ContentText   string `json:"content_text"`
+	Summary       string `json:"summary"`
+	DatePublished string `json:"date_published"`
+	DateModified  string `json:"date_modified"`
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageUrl string         `json:"home_page_url"`
+	FeedUrl     string         `json:"feed_url"`
+	Description string         `json:"description"`
+	Language    string         `json:"language"`
+	Icon        string         `json:"icon"`
+	Favicon     string         `json:"favicon"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+func (feed *jsonFeed) validate() error {
+	if !strings.HasPrefix(feed.Version, jsonFeedVersionPrefix) {
+		return errors.New("not a JSON Feed document")
+	}
+
+	return nil
+}
+
+func mapJsonFeedItem(item jsonFeedItem) rssItem {
+	link := item.Url
+	if link == "" {
+		link = item.ExternalUrl
+	}
+
+	pubDate := item.DatePublished
+	if pubDate == "" {
+		pubDate = item.DateModified
+	}
+
+	content := item.ContentHtml
+	contentType := contentTypeHtml
+	if content == "" {
+		content = item.ContentText
+		contentType = contentTypeText
+	}
+
+	description := item.Summary
+	if description == "" {
+		description = content
+	}
+
+	return rssItem{
+		Title:       item.Title,
+		Link:        link,
+		PubDate:     pubDate,
+		Guid:        item.Id,
+		Description: description,
+		Content:     content,
+		ContentType: contentType,
+	}
+}
+
+func (feed *jsonFeed) image() string {
+	if feed.Icon != "" {
+		return feed.Icon
+	}
+
+	return feed.Favicon
+}
+
+// Map onto the RSS structs so processFeed doesn't need to care about the format
+func (feed *jsonFeed) toRss() *rss {
+	channel := rssChannel{
+		Title:       feed.Title,
+		Link:        feed.HomePageUrl,
+		Description: feed.Description,
+		Language:    feed.Language,
+		Image:       rssImage{Url: feed.image()},
+	}
+
+	for _, item := range feed.Items {
+		channel.Items = append(channel.Items, mapJsonFeedItem(item))
+	}
+
+	return &rss{
+		Channels: []rssChannel{channel},
+	}
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+	"github.com/google/uuid"
+)
+
+// Keys (and session tokens) are 32 random bytes, hex encoded. Only their
+// SHA-256 is stored - they're far too random to brute force, so a slow hash
+// buys nothing
+
+const tokenBytes = 32
+const apiKeyPrefixLength = 8
+const maxApiKeyNameLength = 100
+const defaultApiKeyName = "default"
+
+type createApiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type apiKeyResponse struct {
+	Id         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// Only ever returned by the request that created the key
+type newApiKeyResponse struct {
+	apiKeyResponse
+	ApiKey string `json:"api_key"`
+}
+
+type apiKeyList struct {
+	ApiKeys []apiKeyResponse `json:"api_keys"`
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func generateToken() (string, error) {
+	token := make([]byte, tokenBytes)
+
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+func validateApiKeyName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+
+	if name == "" {
+		return defaultApiKeyName, nil
+	}
+
+	if len([]rune(name)) > maxApiKeyNameLength {
+		return "", errors.New("name is too long")
+	}
+
+	return name, nil
+}
+
+// Returns the params to store and the raw key to hand back to the user
+func createApiKeyParams(userId uuid.UUID, name string, scopes []string) (database.CreateApiKeyParams, string, error) {
+	newId, err := uuid.NewUUID()
+
+	if err != nil {
+		return database.CreateApiKeyParams{}, "", err
+	}
+
+	key, err := generateToken()
+
+	if err != nil {
+		return database.CreateApiKeyParams{}, "", err
+	}
+
+	createdAt := time.Now()
+
+	params := database.CreateApiKeyParams{
+		ID:        newId,
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+		UserID:    userId,
+		Name:      name,
+		KeyHash:   hashToken(key),
+		Prefix:    key[:apiKeyPrefixLength],
+		Scopes:    scopes,
+	}
+
+	return params, key, nil
+}
+
+func mapApiKeyResponse(apiKey database.ApiKey) apiKeyResponse {
+	return apiKeyResponse{
+		Id:         apiKey.ID,
+		CreatedAt:  apiKey.CreatedAt,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     apiKey.Scopes,
+		LastUsedAt: nullTimeResponse(apiKey.LastUsedAt),
+	}
+}
+
+// POST /api/api_keys
+func (config *ApiConfig) CreateApiKey(w http.ResponseWriter, r *http.Request, user database.User) {
+	decoder := json.NewDecoder(r.Body)
+	requestParams := createApiKeyRequest{}
+	err := decoder.Decode(&requestParams)
+
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	name, err := validateApiKeyName(requestParams.Name)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	scopes, err := validateScopes(requestParams.Scopes)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Keys can't hand out more than they have themselves
+	if scope, missing := missingScope(requestScopes(r), scopes); missing {
+		errorResponse(w, http.StatusForbidden, fmt.Sprintf("API key is missing scope %v", scope))
+		return
+	}
+
+	params, key, err := createApiKeyParams(user.ID, name, scopes)
+
+	if err != nil {
+		log.Printf("Error creating new API key params: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error creating API key")
+		return
+	}
+
+	apiKey, err := config.DbConn.CreateApiKey(r.Context(), params)
+
+	if err != nil {
+		log.Printf("Error creating new API key: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error creating API key")
+		return
+	}
+
+	validResponse(w, http.StatusCreated, newApiKeyResponse{
+		apiKeyResponse: mapApiKeyResponse(apiKey),
+		ApiKey:         key,
+	})
+	return
+}
+
+// GET /api/api_keys
+func (config *ApiConfig) GetApiKeys(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+
+	apiKeys, err := config.DbConn.GetApiKeysByUser(r.Context(), user.ID)
+
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Error retrieving API keys")
+		return
+	}
+
+	response := apiKeyList{
+		ApiKeys: []apiKeyResponse{},
+	}
+
+	for _, apiKey := range apiKeys {
+		response.ApiKeys = append(response.ApiKeys, mapApiKeyResponse(apiKey))
+	}
+
+	validResponse(w, http.StatusOK, response)
+	return
+}
+
+// DELETE /api/api_keys/{id}
+// Revoking the key the request was made with is allowed
+func (config *ApiConfig) RevokeApiKey(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+
+	keyId, err := getIdFromUrl(r)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	revoked, err := config.DbConn.RevokeApiKey(r.Context(), database.RevokeApiKeyParams{
+		ID:     keyId,
+		UserID: user.ID,
+	})
+
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Error revoking API key")
+		return
+	}
+
+	if revoked == 0 {
+		errorResponse(w, http.StatusNotFound, "API key not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return
+}
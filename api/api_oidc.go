@@ -0,0 +1,347 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+// Single sign-on with an OpenID Connect provider, using the authorization
+// code flow with PKCE. A successful sign-in ends in the same session cookie
+// as logging in with a password.
+// Users are matched on the provider's subject. The first time someone signs
+// in they're linked to the user with the same email, if the provider has
+// verified it, or a new user is created
+
+const oidcTimeout = 15 * time.Second
+const oidcFlowCookieName = "oidc_flow"
+
+// Long enough to sign in at the provider
+const oidcFlowLifetime = 10 * time.Minute
+
+type OidcConfig struct {
+	// Discovery document is read from <Issuer>/.well-known/openid-configuration
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	// Must match the callback URL registered with the provider
+	RedirectUrl string
+	// Where the browser ends up once signed in
+	PostLoginUrl string
+}
+
+type OidcProvider struct {
+	issuer       string
+	verifier     *oidc.IDTokenVerifier
+	oauth2       oauth2.Config
+	postLoginUrl string
+}
+
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// Reads the provider's discovery document, so fails if it can't be reached
+func NewOidcProvider(ctx context.Context, settings OidcConfig) (*OidcProvider, error) {
+	if settings.ClientId == "" {
+		return nil, errors.New("OIDC client id is required")
+	}
+
+	if settings.RedirectUrl == "" {
+		return nil, errors.New("OIDC redirect url is required")
+	}
+
+	if settings.PostLoginUrl == "" {
+		settings.PostLoginUrl = "/"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, oidcTimeout)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, settings.Issuer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &OidcProvider{
+		issuer:   settings.Issuer,
+		verifier: provider.Verifier(&oidc.Config{ClientID: settings.ClientId}),
+		oauth2: oauth2.Config{
+			ClientID:     settings.ClientId,
+			ClientSecret: settings.ClientSecret,
+			RedirectURL:  settings.RedirectUrl,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		postLoginUrl: settings.PostLoginUrl,
+	}, nil
+}
+
+// Name to give a new user, from the most to least friendly claim there is
+func (claims oidcClaims) displayName() string {
+	for _, name := range []string{claims.Name, claims.PreferredUsername, claims.Email} {
+		if name = strings.TrimSpace(name); name != "" {
+			return name
+		}
+	}
+
+	return claims.Subject
+}
+
+// Only an email the provider has verified is trusted to link accounts
+func (claims oidcClaims) verifiedEmail() string {
+	if !claims.EmailVerified {
+		return ""
+	}
+
+	email, err := validateEmail(claims.Email)
+
+	if err != nil {
+		return ""
+	}
+
+	return email
+}
+
+// State, nonce and PKCE verifier travel together in one short-lived cookie.
+// None of them can contain a "."
+func (config *ApiConfig) setOidcFlowCookie(w http.ResponseWriter, state string, nonce string, verifier string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookieName,
+		Value:    strings.Join([]string{state, nonce, verifier}, "."),
+		Path:     "/",
+		MaxAge:   int(oidcFlowLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   config.SecureCookies,
+		// Lax, so it comes back with the provider's redirect
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (config *ApiConfig) getOidcFlowCookie(w http.ResponseWriter, r *http.Request) (string, string, string, error) {
+	cookie, err := r.Cookie(oidcFlowCookieName)
+
+	if err != nil {
+		return "", "", "", errors.New("sign-in expired, please try again")
+	}
+
+	// Each flow is only good for one callback
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	parts := strings.Split(cookie.Value, ".")
+
+	if len(parts) != 3 {
+		return "", "", "", errors.New("sign-in expired, please try again")
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
+
+// Finds the user for a sign-in, linking or creating one the first time.
+// Two first sign-ins at once race to create the same rows, and the loser's
+// transaction fails on a unique constraint. Trying again finds what the
+// winner created
+func (config *ApiConfig) userForIdentity(ctx context.Context, issuer string, claims oidcClaims) (database.User, error) {
+	user, err := config.linkIdentity(ctx, issuer, claims)
+
+	if isUniqueViolation(err) {
+		return config.linkIdentity(ctx, issuer, claims)
+	}
+
+	return user, err
+}
+
+func (config *ApiConfig) linkIdentity(ctx context.Context, issuer string, claims oidcClaims) (database.User, error) {
+	tx, err := config.Db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return database.User{}, err
+	}
+
+	defer tx.Rollback()
+	queries := config.DbConn.WithTx(tx)
+
+	user, err := queries.GetUserByIdentity(ctx, database.GetUserByIdentityParams{
+		Issuer:  issuer,
+		Subject: claims.Subject,
+	})
+
+	if err == nil {
+		return user, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	email := claims.verifiedEmail()
+	user, err = queries.GetUserByEmail(ctx, nullableString(email))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		params, err := createUserParams(claims.displayName(), email, "")
+
+		if err != nil {
+			return database.User{}, err
+		}
+
+		user, err = queries.CreateUser(ctx, params)
+
+		if err != nil {
+			return database.User{}, err
+		}
+	} else if err != nil {
+		return database.User{}, err
+	}
+
+	newId, err := uuid.NewUUID()
+
+	if err != nil {
+		return database.User{}, err
+	}
+
+	_, err = queries.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		ID:        newId,
+		CreatedAt: time.Now().UTC(),
+		UserID:    user.ID,
+		Issuer:    issuer,
+		Subject:   claims.Subject,
+	})
+
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return user, tx.Commit()
+}
+
+// GET /api/oidc/login
+// Sends the browser to the provider to sign in
+func (config *ApiConfig) OidcLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := generateToken()
+
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Error starting sign-in")
+		return
+	}
+
+	nonce, err := generateToken()
+
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Error starting sign-in")
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	config.setOidcFlowCookie(w, state, nonce, verifier)
+
+	authUrl := config.Oidc.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, authUrl, http.StatusFound)
+	return
+}
+
+// GET /api/oidc/callback
+// Where the provider sends the browser back to, with a code to exchange
+func (config *ApiConfig) OidcCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	state, nonce, verifier, err := config.getOidcFlowCookie(w, r)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		errorResponse(w, http.StatusUnauthorized, fmt.Sprintf("Sign-in was refused: %v", providerErr))
+		return
+	}
+
+	if query.Get("state") != state {
+		errorResponse(w, http.StatusBadRequest, "sign-in expired, please try again")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oidcTimeout)
+	defer cancel()
+
+	token, err := config.Oidc.oauth2.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(verifier))
+
+	if err != nil {
+		log.Printf("Error exchanging OIDC code: %v", err)
+		errorResponse(w, http.StatusBadGateway, "Could not complete sign-in")
+		return
+	}
+
+	rawIdToken, ok := token.Extra("id_token").(string)
+
+	if !ok {
+		errorResponse(w, http.StatusBadGateway, "Provider did not return an ID token")
+		return
+	}
+
+	idToken, err := config.Oidc.verifier.Verify(ctx, rawIdToken)
+
+	if err != nil {
+		log.Printf("Error verifying OIDC ID token: %v", err)
+		errorResponse(w, http.StatusUnauthorized, "Invalid ID token")
+		return
+	}
+
+	if idToken.Nonce != nonce {
+		errorResponse(w, http.StatusUnauthorized, "Invalid ID token")
+		return
+	}
+
+	claims := oidcClaims{}
+	err = idToken.Claims(&claims)
+
+	if err != nil {
+		log.Printf("Error reading OIDC claims: %v", err)
+		errorResponse(w, http.StatusUnauthorized, "Invalid ID token")
+		return
+	}
+
+	user, err := config.userForIdentity(r.Context(), config.Oidc.issuer, claims)
+
+	if err != nil {
+		log.Printf("Error finding user for OIDC subject %v: %v", claims.Subject, err)
+		errorResponse(w, http.StatusInternalServerError, "Error signing in")
+		return
+	}
+
+	err = config.startSession(r.Context(), w, user.ID)
+
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error signing in")
+		return
+	}
+
+	http.Redirect(w, r, config.Oidc.postLoginUrl, http.StatusFound)
+	return
+}
@@ -0,0 +1,545 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// The provider is an httptest server that signs its own ID tokens. The
+// database is an in-memory fake behind database/sql that answers the handful
+// of queries a sign-in makes
+
+const testClientId = "client"
+
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims oidcClaims
+	nonce  string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &mockProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", provider.discovery)
+	mux.HandleFunc("/keys", provider.keys)
+	mux.HandleFunc("/token", provider.token)
+
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+
+	return provider
+}
+
+// What the next ID token says
+func (provider *mockProvider) issue(claims oidcClaims, nonce string) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	provider.claims = claims
+	provider.nonce = nonce
+}
+
+func (provider *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := provider.server.URL
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (provider *mockProvider) keys(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(provider.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(provider.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (provider *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	provider.mu.Lock()
+	claims, nonce := provider.claims, provider.nonce
+	provider.mu.Unlock()
+
+	now := time.Now()
+	idToken, err := provider.sign(map[string]any{
+		"iss":            provider.server.URL,
+		"aud":            testClientId,
+		"sub":            claims.Subject,
+		"email":          claims.Email,
+		"email_verified": claims.EmailVerified,
+		"name":           claims.Name,
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	})
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (provider *mockProvider) sign(payload map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(payload)
+
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, provider.key, crypto.SHA256, digest[:])
+
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+type fakeStore struct {
+	mu         sync.Mutex
+	users      []database.User
+	identities []database.UserIdentity
+	// User each session was started for
+	sessions []uuid.UUID
+	// Set to have the next CreateUserIdentity lose a race to this identity
+	raceWinner *database.UserIdentity
+}
+
+func (store *fakeStore) Connect(ctx context.Context) (driver.Conn, error) {
+	return fakeConn{store: store}, nil
+}
+
+func (store *fakeStore) Driver() driver.Driver {
+	return nil
+}
+
+func (store *fakeStore) userById(id uuid.UUID) (database.User, bool) {
+	for _, user := range store.users {
+		if user.ID == id {
+			return user, true
+		}
+	}
+
+	return database.User{}, false
+}
+
+func (store *fakeStore) identityUser(issuer string, subject string) (uuid.UUID, bool) {
+	for _, identity := range store.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return identity.UserID, true
+		}
+	}
+
+	return uuid.UUID{}, false
+}
+
+func userRow(user database.User) []driver.Value {
+	row := []driver.Value{user.ID.String(), user.CreatedAt, user.UpdatedAt, user.Name, nil, nil}
+
+	if user.Email.Valid {
+		row[4] = user.Email.String
+	}
+
+	if user.PasswordHash.Valid {
+		row[5] = user.PasswordHash.String
+	}
+
+	return row
+}
+
+func nullStringArg(value driver.Value) sql.NullString {
+	text, ok := value.(string)
+	return sql.NullString{String: text, Valid: ok}
+}
+
+// Runs one query, matched on its sqlc name
+func (store *fakeStore) run(query string, args []driver.Value) ([][]driver.Value, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "-- name: GetUserByIdentity "):
+		userId, ok := store.identityUser(args[0].(string), args[1].(string))
+		if !ok {
+			return nil, nil
+		}
+
+		user, _ := store.userById(userId)
+		return [][]driver.Value{userRow(user)}, nil
+	case strings.HasPrefix(query, "-- name: GetUserByEmail "):
+		email := nullStringArg(args[0])
+		for _, user := range store.users {
+			if email.Valid && user.Email == email {
+				return [][]driver.Value{userRow(user)}, nil
+			}
+		}
+
+		return nil, nil
+	case strings.HasPrefix(query, "-- name: CreateUser "):
+		user := database.User{
+			ID:           uuid.MustParse(args[0].(string)),
+			CreatedAt:    args[1].(time.Time),
+			UpdatedAt:    args[2].(time.Time),
+			Name:         args[3].(string),
+			Email:        nullStringArg(args[4]),
+			PasswordHash: nullStringArg(args[5]),
+		}
+		store.users = append(store.users, user)
+		return [][]driver.Value{userRow(user)}, nil
+	case strings.HasPrefix(query, "-- name: CreateUserIdentity "):
+		if store.raceWinner != nil {
+			store.identities = append(store.identities, *store.raceWinner)
+			store.raceWinner = nil
+			return nil, &pq.Error{Code: "23505"}
+		}
+
+		identity := database.UserIdentity{
+			ID:        uuid.MustParse(args[0].(string)),
+			CreatedAt: args[1].(time.Time),
+			UserID:    uuid.MustParse(args[2].(string)),
+			Issuer:    args[3].(string),
+			Subject:   args[4].(string),
+		}
+
+		if _, ok := store.identityUser(identity.Issuer, identity.Subject); ok {
+			return nil, &pq.Error{Code: "23505"}
+		}
+
+		store.identities = append(store.identities, identity)
+		return [][]driver.Value{{identity.ID.String(), identity.CreatedAt, identity.UserID.String(), identity.Issuer, identity.Subject}}, nil
+	case strings.HasPrefix(query, "-- name: DeleteExpiredSessions "):
+		return nil, nil
+	case strings.HasPrefix(query, "-- name: CreateSession "):
+		userId := uuid.MustParse(args[2].(string))
+		store.sessions = append(store.sessions, userId)
+		return [][]driver.Value{{args[0], args[1], args[2], args[3], args[4]}}, nil
+	default:
+		return nil, fmt.Errorf("unexpected query: %v", query)
+	}
+}
+
+type fakeConn struct {
+	store *fakeStore
+}
+
+func (conn fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{store: conn.store, query: query}, nil
+}
+
+func (conn fakeConn) Close() error {
+	return nil
+}
+
+// Writes land straight away, so a rollback keeps them
+func (conn fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (tx fakeTx) Commit() error {
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	store *fakeStore
+	query string
+}
+
+func (stmt fakeStmt) Close() error {
+	return nil
+}
+
+func (stmt fakeStmt) NumInput() int {
+	return -1
+}
+
+func (stmt fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	rows, err := stmt.store.run(stmt.query, args)
+	return driver.RowsAffected(len(rows)), err
+}
+
+func (stmt fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := stmt.store.run(stmt.query, args)
+	return &fakeRows{rows: rows}, err
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+// Only the count matters - sqlc scans by position
+func (rows *fakeRows) Columns() []string {
+	if len(rows.rows) == 0 {
+		return nil
+	}
+
+	return make([]string, len(rows.rows[0]))
+}
+
+func (rows *fakeRows) Close() error {
+	return nil
+}
+
+func (rows *fakeRows) Next(dest []driver.Value) error {
+	if len(rows.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, rows.rows[0])
+	rows.rows = rows.rows[1:]
+	return nil
+}
+
+func newOidcTestConfig(t *testing.T, provider *mockProvider, store *fakeStore) *ApiConfig {
+	oidcProvider, err := NewOidcProvider(context.Background(), OidcConfig{
+		Issuer:       provider.server.URL,
+		ClientId:     testClientId,
+		ClientSecret: "secret",
+		RedirectUrl:  "http://localhost/api/oidc/callback",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(store)
+	t.Cleanup(func() { db.Close() })
+
+	return &ApiConfig{
+		DbConn: database.New(db),
+		Db:     db,
+		Oidc:   oidcProvider,
+	}
+}
+
+// Starts a sign-in, has the provider sign in claims, and follows the
+// redirect back. tamper can change the state and nonce on the way
+func signIn(t *testing.T, config *ApiConfig, provider *mockProvider, claims oidcClaims, tamper func(state *string, nonce *string)) *httptest.ResponseRecorder {
+	login := httptest.NewRecorder()
+	config.OidcLogin(login, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+
+	if login.Code != http.StatusFound {
+		t.Fatalf("login: got status %v, want %v", login.Code, http.StatusFound)
+	}
+
+	authUrl, err := url.Parse(login.Header().Get("Location"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, nonce := authUrl.Query().Get("state"), authUrl.Query().Get("nonce")
+	if tamper != nil {
+		tamper(&state, &nonce)
+	}
+	provider.issue(claims, nonce)
+
+	callback := httptest.NewRequest(http.MethodGet, "/api/oidc/callback?code=code&state="+url.QueryEscape(state), nil)
+	for _, cookie := range login.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+
+	recorder := httptest.NewRecorder()
+	config.OidcCallback(recorder, callback)
+
+	return recorder
+}
+
+func existingUser(email string) database.User {
+	createdAt := time.Now().UTC()
+
+	return database.User{
+		ID:        uuid.New(),
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+		Name:      "Jo",
+		Email:     nullableString(email),
+	}
+}
+
+func TestOidcCallbackStateMismatch(t *testing.T) {
+	provider := newMockProvider(t)
+	store := &fakeStore{}
+	config := newOidcTestConfig(t, provider, store)
+
+	resp := signIn(t, config, provider, oidcClaims{Subject: "subject"}, func(state *string, nonce *string) {
+		*state = "forged"
+	})
+
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("got status %v, want %v", resp.Code, http.StatusBadRequest)
+	}
+
+	if len(store.sessions) != 0 {
+		t.Errorf("got %v sessions, want none", len(store.sessions))
+	}
+}
+
+func TestOidcCallbackNonceMismatch(t *testing.T) {
+	provider := newMockProvider(t)
+	store := &fakeStore{}
+	config := newOidcTestConfig(t, provider, store)
+
+	resp := signIn(t, config, provider, oidcClaims{Subject: "subject"}, func(state *string, nonce *string) {
+		*nonce = "replayed"
+	})
+
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("got status %v, want %v", resp.Code, http.StatusUnauthorized)
+	}
+
+	if len(store.users) != 0 || len(store.sessions) != 0 {
+		t.Errorf("got %v users and %v sessions, want none", len(store.users), len(store.sessions))
+	}
+}
+
+func TestOidcCallbackUnverifiedEmailNotLinked(t *testing.T) {
+	provider := newMockProvider(t)
+	existing := existingUser("jo@example.com")
+	store := &fakeStore{users: []database.User{existing}}
+	config := newOidcTestConfig(t, provider, store)
+
+	resp := signIn(t, config, provider, oidcClaims{
+		Subject:       "subject",
+		Email:         "jo@example.com",
+		EmailVerified: false,
+		Name:          "Not Jo",
+	}, nil)
+
+	if resp.Code != http.StatusFound {
+		t.Fatalf("got status %v, want %v: %v", resp.Code, http.StatusFound, resp.Body.String())
+	}
+
+	userId, ok := store.identityUser(provider.server.URL, "subject")
+	if !ok {
+		t.Fatal("identity was not stored")
+	}
+
+	if userId == existing.ID {
+		t.Error("identity was linked to the user with the unverified email")
+	}
+
+	user, _ := store.userById(userId)
+	if user.Email.Valid {
+		t.Errorf("new user got email %q, want none", user.Email.String)
+	}
+
+	if len(store.sessions) != 1 || store.sessions[0] != userId {
+		t.Errorf("got sessions %v, want one for %v", store.sessions, userId)
+	}
+}
+
+func TestOidcCallbackVerifiedEmailLinked(t *testing.T) {
+	provider := newMockProvider(t)
+	existing := existingUser("jo@example.com")
+	store := &fakeStore{users: []database.User{existing}}
+	config := newOidcTestConfig(t, provider, store)
+
+	resp := signIn(t, config, provider, oidcClaims{
+		Subject:       "subject",
+		Email:         "Jo@Example.com",
+		EmailVerified: true,
+	}, nil)
+
+	if resp.Code != http.StatusFound {
+		t.Fatalf("got status %v, want %v: %v", resp.Code, http.StatusFound, resp.Body.String())
+	}
+
+	userId, ok := store.identityUser(provider.server.URL, "subject")
+	if !ok || userId != existing.ID {
+		t.Errorf("identity linked to %v, want %v", userId, existing.ID)
+	}
+
+	if len(store.users) != 1 {
+		t.Errorf("got %v users, want 1", len(store.users))
+	}
+
+	if len(store.sessions) != 1 || store.sessions[0] != existing.ID {
+		t.Errorf("got sessions %v, want one for %v", store.sessions, existing.ID)
+	}
+}
+
+func TestOidcCallbackConcurrentFirstSignIn(t *testing.T) {
+	provider := newMockProvider(t)
+	winner := existingUser("")
+	store := &fakeStore{
+		users: []database.User{winner},
+		raceWinner: &database.UserIdentity{
+			ID:      uuid.New(),
+			UserID:  winner.ID,
+			Issuer:  provider.server.URL,
+			Subject: "subject",
+		},
+	}
+	config := newOidcTestConfig(t, provider, store)
+
+	resp := signIn(t, config, provider, oidcClaims{Subject: "subject"}, nil)
+
+	if resp.Code != http.StatusFound {
+		t.Fatalf("got status %v, want %v: %v", resp.Code, http.StatusFound, resp.Body.String())
+	}
+
+	if len(store.sessions) != 1 || store.sessions[0] != winner.ID {
+		t.Errorf("got sessions %v, want one for %v", store.sessions, winner.ID)
+	}
+}
@@ -0,0 +1,362 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+)
+
+// OPML 2.0 subscription lists
+// http://opml.org/spec2.opml
+
+// Column sizes from the feeds table
+const maxFeedNameLength = 100
+const maxFeedUrlLength = 150
+
+const maxOpmlBytes = 1 << 20
+
+// New feeds are fetched before they're added, so an import is bounded in
+// how many outlines it takes and how long it spends fetching. Importing the
+// same file again picks up where a cut-short import left off
+const maxOpmlOutlines = 500
+const opmlDiscoveryBudget = 2 * time.Minute
+
+const (
+	importCreated  = "created"
+	importFollowed = "followed"
+	importSkipped  = "skipped"
+	importInvalid  = "invalid"
+	importFailed   = "failed"
+)
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XmlUrl   string        `xml:"xmlUrl,attr,omitempty"`
+	HtmlUrl  string        `xml:"htmlUrl,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type opmlBody struct {
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opml struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    opmlHead `xml:"head"`
+	Body    opmlBody `xml:"body"`
+}
+
+type opmlImportResult struct {
+	Title  string  `json:"title"`
+	Url    string  `json:"url"`
+	Folder *string `json:"folder"`
+	Status string  `json:"status"`
+	Error  string  `json:"error,omitempty"`
+}
+
+type opmlImportResponse struct {
+	Results []opmlImportResult `json:"results"`
+}
+
+func (outline opmlOutline) name() string {
+	if outline.Title != "" {
+		return outline.Title
+	}
+
+	return outline.Text
+}
+
+// Feeds without a folder sit at the top level, the rest are grouped under one outline per folder
+func buildOpml(feeds []database.GetFollowedFeedsRow, userName string) opml {
+	doc := opml{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       userName + " subscriptions",
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+
+	folderIndexes := map[string]int{}
+
+	for _, feed := range feeds {
+		outline := opmlOutline{
+			Text:   feed.Name,
+			Title:  feed.Name,
+			Type:   "rss",
+			XmlUrl: feed.Url,
+		}
+
+		if !feed.Folder.Valid {
+			doc.Body.Outlines = append(doc.Body.Outlines, outline)
+			continue
+		}
+
+		index, ok := folderIndexes[feed.Folder.String]
+		if !ok {
+			index = len(doc.Body.Outlines)
+			folderIndexes[feed.Folder.String] = index
+			doc.Body.Outlines = append(doc.Body.Outlines, opmlOutline{
+				Text:  feed.Folder.String,
+				Title: feed.Folder.String,
+			})
+		}
+
+		doc.Body.Outlines[index].Outlines = append(doc.Body.Outlines[index].Outlines, outline)
+	}
+
+	return doc
+}
+
+func validateFeedUrl(rawUrl string) error {
+	if len(rawUrl) > maxFeedUrlLength {
+		return errors.New("url is too long")
+	}
+
+	parsed, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return errors.New("url is not valid")
+	}
+
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("url must be an absolute http(s) url")
+	}
+
+	return nil
+}
+
+func truncateFeedName(name string) string {
+	runes := []rune(name)
+
+	if len(runes) > maxFeedNameLength {
+		return string(runes[:maxFeedNameLength])
+	}
+
+	return name
+}
+
+// Nested folders are flattened into a single path, e.g. "Tech/Go"
+func flattenOpmlOutlines(outlines []opmlOutline, folder string) []opmlImportResult {
+	var results []opmlImportResult
+
+	for _, outline := range outlines {
+		if outline.XmlUrl == "" {
+			if len(outline.Outlines) == 0 {
+				continue
+			}
+
+			childFolder := strings.TrimSpace(outline.name())
+			if folder != "" {
+				childFolder = folder + "/" + childFolder
+			}
+
+			results = append(results, flattenOpmlOutlines(outline.Outlines, childFolder)...)
+			continue
+		}
+
+		result := opmlImportResult{
+			Title: strings.TrimSpace(outline.name()),
+			Url:   strings.TrimSpace(outline.XmlUrl),
+		}
+
+		if folder != "" {
+			folderName := folder
+			result.Folder = &folderName
+		}
+
+		if result.Title == "" {
+			result.Title = result.Url
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func importFailure(outline opmlImportResult, status string, err error) opmlImportResult {
+	outline.Status = status
+	outline.Error = err.Error()
+	return outline
+}
+
+// Creates the feed if nobody has added it yet, then follows it. New feeds are
+// checked and discovered the same way as ones added through POST /api/feeds
+func (config *ApiConfig) importOpmlOutline(ctx context.Context, outline opmlImportResult, user database.User, discoverUntil time.Time) opmlImportResult {
+	err := validateFeedUrl(outline.Url)
+
+	if err != nil {
+		return importFailure(outline, importInvalid, err)
+	}
+
+	feed, err := config.DbConn.GetFeedByUrl(ctx, outline.Url)
+	created := false
+
+	if errors.Is(err, sql.ErrNoRows) {
+		feed, created, err = config.importNewFeed(ctx, outline, user, discoverUntil)
+	}
+
+	if err != nil {
+		return importFailure(outline, importFailed, err)
+	}
+
+	if created {
+		outline.Status = importCreated
+	} else {
+		_, err = config.DbConn.GetFollowByFeed(ctx, database.GetFollowByFeedParams{
+			UserID: user.ID,
+			FeedID: feed.ID,
+		})
+
+		if err == nil {
+			return importFailure(outline, importSkipped, errors.New("already following"))
+		}
+
+		if !errors.Is(err, sql.ErrNoRows) {
+			return importFailure(outline, importFailed, err)
+		}
+
+		outline.Status = importFollowed
+	}
+
+	followParams, err := createFollowParams(user.ID, feed.ID)
+
+	if err != nil {
+		return importFailure(outline, importFailed, err)
+	}
+
+	if outline.Folder != nil {
+		followParams.Folder = nullableString(*outline.Folder)
+	}
+
+	_, err = config.DbConn.CreateFollow(ctx, followParams)
+
+	if err != nil {
+		return importFailure(outline, importFailed, err)
+	}
+
+	return outline
+}
+
+// Outlines pointing at a page rather than a feed are imported as the feed the
+// page links to. False if that had already been added, so nothing was created
+func (config *ApiConfig) importNewFeed(ctx context.Context, outline opmlImportResult, user database.User, discoverUntil time.Time) (database.Feed, bool, error) {
+	if !time.Now().Before(discoverUntil) {
+		return database.Feed{}, false, errors.New("import ran out of time, import the file again to add the rest")
+	}
+
+	// Each feed gets the usual time to answer, unless the import runs out first
+	discoverCtx, cancel := context.WithTimeout(ctx, min(discoveryTimeout, time.Until(discoverUntil)))
+	defer cancel()
+
+	candidate, candidates, err := discoverNewFeed(discoverCtx, outline.Url)
+
+	if err != nil {
+		return database.Feed{}, false, err
+	}
+
+	if len(candidates) > 1 {
+		return database.Feed{}, false, errors.New("url offers more than one feed, add the one you want directly")
+	}
+
+	if candidate.Url != outline.Url {
+		feed, err := config.DbConn.GetFeedByUrl(ctx, candidate.Url)
+
+		if err == nil {
+			return feed, false, nil
+		}
+
+		if !errors.Is(err, sql.ErrNoRows) {
+			return database.Feed{}, false, err
+		}
+	}
+
+	feedParams, err := createFeedParams(truncateFeedName(outline.Title), candidate.Url, user.ID)
+
+	if err != nil {
+		return database.Feed{}, false, err
+	}
+
+	setFeedMetadata(&feedParams, candidate.feed)
+	feed, err := config.DbConn.CreateFeed(ctx, feedParams)
+
+	return feed, err == nil, err
+}
+
+// GET /api/opml
+func (config *ApiConfig) ExportOpml(w http.ResponseWriter, r *http.Request, user database.User) {
+	feeds, err := config.DbConn.GetFollowedFeeds(context.TODO(), user.ID)
+
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Error retrieving follows")
+		return
+	}
+
+	resp, err := xml.MarshalIndent(buildOpml(feeds, user.Name), "", "  ")
+
+	if err != nil {
+		log.Printf("Error marshalling OPML: %s", err)
+		errorResponse(w, http.StatusInternalServerError, "Error building OPML")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="subscriptions.opml"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(resp)
+	return
+}
+
+// POST /api/opml
+func (config *ApiConfig) ImportOpml(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var doc opml
+	decoder := newXmlDecoder(http.MaxBytesReader(w, r.Body, maxOpmlBytes))
+	err := decoder.Decode(&doc)
+
+	if err != nil {
+		log.Printf("Error decoding OPML: %s", err)
+		errorResponse(w, http.StatusBadRequest, "Invalid OPML document")
+		return
+	}
+
+	outlines := flattenOpmlOutlines(doc.Body.Outlines, "")
+
+	if len(outlines) > maxOpmlOutlines {
+		errorResponse(w, http.StatusBadRequest, fmt.Sprintf("OPML has more than %v feeds", maxOpmlOutlines))
+		return
+	}
+
+	response := opmlImportResponse{
+		Results: []opmlImportResult{},
+	}
+	discoverUntil := time.Now().Add(opmlDiscoveryBudget)
+
+	for _, outline := range outlines {
+		result := config.importOpmlOutline(r.Context(), outline, user, discoverUntil)
+		log.Printf("OPML import %v: %v", result.Url, result.Status)
+		response.Results = append(response.Results, result)
+	}
+
+	validResponse(w, http.StatusOK, response)
+	return
+}
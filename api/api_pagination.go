@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultPageLimit = 20
+const maxPageLimit = 100
+
+// Keyset cursors point at the last post of a page: (published_at, id).
+// They're opaque to clients, so just base64 the two values
+type postCursor struct {
+	PublishedAt time.Time
+	Id          uuid.UUID
+}
+
+func encodePostCursor(publishedAt time.Time, id uuid.UUID) string {
+	raw := publishedAt.UTC().Format(time.RFC3339Nano) + "," + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePostCursor(cursor string) (postCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+
+	if err != nil {
+		return postCursor{}, errors.New("invalid cursor")
+	}
+
+	publishedAt, id, found := strings.Cut(string(raw), ",")
+
+	if !found {
+		return postCursor{}, errors.New("invalid cursor")
+	}
+
+	parsedTime, err := time.Parse(time.RFC3339Nano, publishedAt)
+
+	if err != nil {
+		return postCursor{}, errors.New("invalid cursor")
+	}
+
+	parsedId, err := uuid.Parse(id)
+
+	if err != nil {
+		return postCursor{}, errors.New("invalid cursor")
+	}
+
+	return postCursor{
+		PublishedAt: parsedTime,
+		Id:          parsedId,
+	}, nil
+}
+
+// Search results are ordered by rank rather than date: (rank, id)
+type searchCursor struct {
+	Rank float32
+	Id   uuid.UUID
+}
+
+func encodeSearchCursor(rank float32, id uuid.UUID) string {
+	raw := strconv.FormatFloat(float64(rank), 'g', -1, 32) + "," + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeSearchCursor(cursor string) (searchCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+
+	if err != nil {
+		return searchCursor{}, errors.New("invalid cursor")
+	}
+
+	rank, id, found := strings.Cut(string(raw), ",")
+
+	if !found {
+		return searchCursor{}, errors.New("invalid cursor")
+	}
+
+	parsedRank, err := strconv.ParseFloat(rank, 32)
+
+	if err != nil {
+		return searchCursor{}, errors.New("invalid cursor")
+	}
+
+	parsedId, err := uuid.Parse(id)
+
+	if err != nil {
+		return searchCursor{}, errors.New("invalid cursor")
+	}
+
+	return searchCursor{
+		Rank: float32(parsedRank),
+		Id:   parsedId,
+	}, nil
+}
+
+func getPageLimit(query url.Values) (int, error) {
+	value := query.Get("limit")
+
+	if value == "" {
+		return defaultPageLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+	}
+
+	return limit, nil
+}
+
+// Timestamps are RFC3339, e.g. 2023-09-01T00:00:00Z
+func getTimeParam(query url.Values, name string) (*time.Time, error) {
+	value := query.Get(name)
+
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		return nil, errors.New(name + " must be an RFC3339 timestamp")
+	}
+
+	// Columns have no time zone and are stored as UTC
+	parsed = parsed.UTC()
+	return &parsed, nil
+}
+
+func getUuidParam(query url.Values, name string) (*uuid.UUID, error) {
+	value := query.Get(name)
+
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := uuid.Parse(value)
+
+	if err != nil {
+		return nil, errors.New(name + " must be a UUID")
+	}
+
+	return &parsed, nil
+}
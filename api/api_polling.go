@@ -0,0 +1,161 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+)
+
+// Each feed is polled about as often as it posts. Publishers can ask for
+// less (RSS ttl, the syndication module, Cache-Control, Retry-After) and
+// always get it, within the bounds below
+
+const defaultPollInterval = time.Hour
+const minPollInterval = 15 * time.Minute
+const maxPollInterval = 24 * time.Hour
+
+// Only recent posts say much about how often a feed posts now
+const postingSampleSize = 10
+
+var syndicationPeriods = map[string]time.Duration{
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+	"yearly":  365 * 24 * time.Hour,
+}
+
+// Median gap between the most recent posts, if there are enough dated posts to tell
+func postingInterval(feed *rss) (time.Duration, bool) {
+	var dates []time.Time
+
+	for _, channel := range feed.Channels {
+		for _, item := range channel.Items {
+			publishedAt, err := parsePublishDate(item.PubDate)
+			if err == nil {
+				dates = append(dates, publishedAt)
+			}
+		}
+	}
+
+	// Newest first
+	slices.SortFunc(dates, func(a, b time.Time) int {
+		return b.Compare(a)
+	})
+
+	if len(dates) > postingSampleSize {
+		dates = dates[:postingSampleSize]
+	}
+
+	if len(dates) < 2 {
+		return 0, false
+	}
+
+	var gaps []time.Duration
+	for i := 1; i < len(dates); i++ {
+		gaps = append(gaps, dates[i-1].Sub(dates[i]))
+	}
+
+	slices.Sort(gaps)
+	return gaps[len(gaps)/2], true
+}
+
+// sy:updatePeriod defaults to daily and sy:updateFrequency to once per period
+func syndicationInterval(period string, frequency string) time.Duration {
+	if period == "" && frequency == "" {
+		return 0
+	}
+
+	periodLength, ok := syndicationPeriods[strings.ToLower(strings.TrimSpace(period))]
+	if !ok {
+		periodLength = syndicationPeriods["daily"]
+	}
+
+	times, err := strconv.Atoi(strings.TrimSpace(frequency))
+	if err != nil || times < 1 {
+		times = 1
+	}
+
+	return periodLength / time.Duration(times)
+}
+
+// The longest interval any channel asks for. ttl is in minutes
+func publisherInterval(feed *rss) time.Duration {
+	var interval time.Duration
+
+	for _, channel := range feed.Channels {
+		if minutes, err := strconv.Atoi(strings.TrimSpace(channel.Ttl)); err == nil && minutes > 0 {
+			interval = max(interval, time.Duration(minutes)*time.Minute)
+		}
+
+		interval = max(interval, syndicationInterval(channel.UpdatePeriod, channel.UpdateFrequency))
+	}
+
+	return interval
+}
+
+// s-maxage is meant for shared caches, which is what we are
+func cacheMaxAge(header string) time.Duration {
+	var maxAge time.Duration
+
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			return 0
+		case "max-age", "s-maxage":
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err == nil && seconds > 0 {
+				maxAge = max(maxAge, time.Duration(seconds)*time.Second)
+			}
+		}
+	}
+
+	return maxAge
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func retryAfter(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+
+	if retryAt, err := http.ParseTime(header); err == nil {
+		return max(retryAt.Sub(now), 0)
+	}
+
+	return 0
+}
+
+// How long to wait before fetching feed again after a successful fetch.
+// A 304 has no posts to go on, so the last interval carries over
+func pollInterval(feed database.Feed, result *fetchResult) time.Duration {
+	interval := defaultPollInterval
+
+	if feed.FetchIntervalSeconds.Valid {
+		interval = time.Duration(feed.FetchIntervalSeconds.Int32) * time.Second
+	}
+
+	if result.Feed != nil {
+		if observed, ok := postingInterval(result.Feed); ok {
+			interval = observed
+		}
+
+		interval = max(interval, publisherInterval(result.Feed))
+	}
+
+	interval = max(interval, result.MaxAge, result.RetryAfter)
+
+	return min(max(interval, minPollInterval), maxPollInterval)
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+	"github.com/google/uuid"
+)
+
+type postResponse struct {
+	Id          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Title       string    `json:"title"`
+	Url         string    `json:"url"`
+	Description string    `json:"description"`
+	Excerpt     string    `json:"excerpt"`
+	PublishedAt time.Time `json:"published_at"`
+	FeedID      uuid.UUID `json:"feed_id"`
+	FeedName    string    `json:"feed_name"`
+	FeedUrl     string    `json:"feed_url"`
+	Read        bool      `json:"read"`
+}
+
+type postDetailResponse struct {
+	postResponse
+	Content     string `json:"content"`
+	ContentType string `json:"content_type"`
+}
+
+type postList struct {
+	Posts      []postResponse `json:"posts"`
+	NextCursor *string        `json:"next_cursor"`
+}
+
+func nullTimeParam(value *time.Time) sql.NullTime {
+	if value == nil {
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{
+		Time:  *value,
+		Valid: true,
+	}
+}
+
+func nullUuidParam(value *uuid.UUID) uuid.NullUUID {
+	if value == nil {
+		return uuid.NullUUID{}
+	}
+
+	return uuid.NullUUID{
+		UUID:  *value,
+		Valid: true,
+	}
+}
+
+// Query params: limit, cursor, feed_id, since, until, unread
+// One extra row is requested to tell whether there's another page
+func getPostsByUserParams(userId uuid.UUID, query url.Values) (database.GetPostsByUserParams, error) {
+	limit, err := getPageLimit(query)
+
+	if err != nil {
+		return database.GetPostsByUserParams{}, err
+	}
+
+	feedId, err := getUuidParam(query, "feed_id")
+
+	if err != nil {
+		return database.GetPostsByUserParams{}, err
+	}
+
+	since, err := getTimeParam(query, "since")
+
+	if err != nil {
+		return database.GetPostsByUserParams{}, err
+	}
+
+	until, err := getTimeParam(query, "until")
+
+	if err != nil {
+		return database.GetPostsByUserParams{}, err
+	}
+
+	unreadOnly := false
+	if unread := query.Get("unread"); unread != "" {
+		unreadOnly, err = strconv.ParseBool(unread)
+
+		if err != nil {
+			return database.GetPostsByUserParams{}, errors.New("unread must be true or false")
+		}
+	}
+
+	params := database.GetPostsByUserParams{
+		UserID:     userId,
+		FeedID:     nullUuidParam(feedId),
+		Since:      nullTimeParam(since),
+		Until:      nullTimeParam(until),
+		UnreadOnly: unreadOnly,
+		Limit:      int32(limit + 1),
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		decoded, err := decodePostCursor(cursor)
+
+		if err != nil {
+			return database.GetPostsByUserParams{}, err
+		}
+
+		params.CursorPublishedAt = nullTimeParam(&decoded.PublishedAt)
+		params.CursorID = nullUuidParam(&decoded.Id)
+	}
+
+	return params, nil
+}
+
+func mapPostList(posts []database.GetPostsByUserRow, limit int) postList {
+	response := postList{
+		Posts: []postResponse{},
+	}
+
+	if len(posts) > limit {
+		posts = posts[:limit]
+		last := posts[len(posts)-1]
+		nextCursor := encodePostCursor(last.PublishedAt, last.ID)
+		response.NextCursor = &nextCursor
+	}
+
+	for _, post := range posts {
+		response.Posts = append(response.Posts, postResponse{
+			Id:          post.ID,
+			CreatedAt:   post.CreatedAt,
+			UpdatedAt:   post.UpdatedAt,
+			Title:       post.Title,
+			Url:         post.Url,
+			Description: post.Description.String,
+			Excerpt:     post.Excerpt.String,
+			PublishedAt: post.PublishedAt,
+			FeedID:      post.FeedID,
+			FeedName:    post.FeedName,
+			FeedUrl:     post.FeedUrl,
+			Read:        post.IsRead,
+		})
+	}
+
+	return response
+}
+
+// GET /api/posts
+func (config *ApiConfig) GetPostsForUser(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params, err := getPostsByUserParams(user.ID, r.URL.Query())
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	posts, err := config.DbConn.GetPostsByUser(context.TODO(), params)
+
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Error retrieving posts")
+		return
+	}
+
+	validResponse(w, http.StatusOK, mapPostList(posts, int(params.Limit)-1))
+	return
+}
+
+// GET /api/posts/{id}
+func (config *ApiConfig) GetPostForUser(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+	postId, err := getIdFromUrl(r)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	post, err := config.DbConn.GetPostForUser(context.TODO(), database.GetPostForUserParams{
+		ID:     postId,
+		UserID: user.ID,
+	})
+
+	// Posts from feeds the user doesn't follow are treated as missing
+	if errors.Is(err, sql.ErrNoRows) {
+		errorResponse(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Error retrieving post")
+		return
+	}
+
+	validResponse(w, http.StatusOK, postDetailResponse{
+		postResponse: postResponse{
+			Id:          post.ID,
+			CreatedAt:   post.CreatedAt,
+			UpdatedAt:   post.UpdatedAt,
+			Title:       post.Title,
+			Url:         post.Url,
+			Description: post.Description.String,
+			Excerpt:     post.Excerpt.String,
+			PublishedAt: post.PublishedAt,
+			FeedID:      post.FeedID,
+			FeedName:    post.FeedName,
+			FeedUrl:     post.FeedUrl,
+			Read:        post.IsRead,
+		},
+		Content:     post.Content.String,
+		ContentType: post.ContentType.String,
+	})
+	return
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token buckets: each client can make up to Requests at once, and gets them
+// back steadily over Period. Every route group has its own limiter, given
+// when routes are registered in getApiRouterV1.
+// Buckets are kept in memory, so each instance enforces its limits separately
+
+// Past this many buckets, new clients share one until a sweep makes room
+const maxRateLimitBuckets = 100_000
+const overflowBucketKey = "overflow"
+
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Identifies who a request counts against
+type rateLimitKey func(*http.Request) string
+
+type RateLimiter struct {
+	limit RateLimit
+	key   rateLimitKey
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	// Full buckets are dropped at most once a period
+	sweptAt time.Time
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type rateLimitDecision struct {
+	Allowed   bool
+	Remaining int
+	// Until the next request would be allowed. Zero if it already is
+	RetryAfter time.Duration
+	// Until the bucket is full again
+	Reset time.Duration
+}
+
+// Limits are written <requests>/<period>, e.g. 60/1m, or "off"
+func ParseRateLimit(value string) (RateLimit, error) {
+	value = strings.TrimSpace(value)
+
+	if strings.EqualFold(value, "off") {
+		return RateLimit{}, nil
+	}
+
+	requests, period, found := strings.Cut(value, "/")
+
+	if !found {
+		return RateLimit{}, fmt.Errorf("rate limit %q should look like 60/1m", value)
+	}
+
+	limit := RateLimit{}
+	var err error
+
+	limit.Requests, err = strconv.Atoi(strings.TrimSpace(requests))
+
+	if err != nil || limit.Requests <= 0 {
+		return RateLimit{}, errors.New("rate limit requests must be a positive number")
+	}
+
+	limit.Period, err = time.ParseDuration(strings.TrimSpace(period))
+
+	if err != nil || limit.Period <= 0 {
+		return RateLimit{}, errors.New("rate limit period must be a positive duration")
+	}
+
+	return limit, nil
+}
+
+// Unauthenticated routes are limited per client IP. Behind a proxy, put
+// chi's RealIP middleware in front so this sees the client's address
+func ClientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+// Authenticated routes are limited per API key, or per session for the web
+// frontend. Only hashes are kept, and requests with neither fall back to
+// the client IP.
+// This runs before AuthMiddleware, so the credential hasn't been checked and
+// a client can get a fresh bucket by making one up. Only use it behind a
+// ClientKey limiter, which caps how fast one client can do that
+func CredentialKey(r *http.Request) string {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		return "key:" + hashToken(authHeader)
+	}
+
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		return "session:" + hashToken(cookie.Value)
+	}
+
+	return ClientKey(r)
+}
+
+// A zero limit lets everything through
+func NewRateLimiter(limit RateLimit, key rateLimitKey) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		key:     key,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// Tokens added per second
+func (limiter *RateLimiter) rate() float64 {
+	return float64(limiter.limit.Requests) / limiter.limit.Period.Seconds()
+}
+
+func (limiter *RateLimiter) take(key string, now time.Time) rateLimitDecision {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.sweep(now)
+
+	capacity := float64(limiter.limit.Requests)
+	rate := limiter.rate()
+
+	bucket, ok := limiter.buckets[key]
+	if !ok && len(limiter.buckets) >= maxRateLimitBuckets {
+		key = overflowBucketKey
+		bucket, ok = limiter.buckets[key]
+	}
+
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updatedAt: now}
+		limiter.buckets[key] = bucket
+	}
+
+	elapsed := max(now.Sub(bucket.updatedAt).Seconds(), 0)
+	bucket.tokens = min(bucket.tokens+elapsed*rate, capacity)
+	bucket.updatedAt = now
+
+	decision := rateLimitDecision{}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = secondsDuration((1 - bucket.tokens) / rate)
+	}
+
+	decision.Remaining = int(bucket.tokens)
+	decision.Reset = secondsDuration((capacity - bucket.tokens) / rate)
+
+	return decision
+}
+
+// Buckets that have filled back up are the same as no bucket at all
+func (limiter *RateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.sweptAt) < limiter.limit.Period {
+		return
+	}
+
+	capacity := float64(limiter.limit.Requests)
+	rate := limiter.rate()
+
+	for key, bucket := range limiter.buckets {
+		if bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*rate >= capacity {
+			delete(limiter.buckets, key)
+		}
+	}
+
+	limiter.sweptAt = now
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Header values are whole seconds, rounded up so clients never retry early
+func ceilSeconds(duration time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(duration.Seconds())))
+}
+
+// Middleware for a chi router. Every response says where the client stands,
+// and requests over the limit get a 429 with Retry-After
+func (limiter *RateLimiter) Limit(next http.Handler) http.Handler {
+	if limiter.limit.Requests <= 0 {
+		return next
+	}
+
+	policy := fmt.Sprintf("%v;w=%v", limiter.limit.Requests, ceilSeconds(limiter.limit.Period))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decision := limiter.take(limiter.key(r), time.Now())
+
+		w.Header().Set("RateLimit-Policy", policy)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limiter.limit.Requests))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(decision.Reset))
+
+		if !decision.Allowed {
+			w.Header().Set("Retry-After", ceilSeconds(decision.RetryAfter))
+			errorResponse(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,67 @@
+package api
+
+// RSS 1.0 (and 0.90) feeds, which are RDF documents.
+// Unlike RSS 2.0 the items and image sit next to the channel rather than
+// inside it - the channel only lists them by reference
+
+const rdfNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+type rdfItem struct {
+	About       string `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	// Dublin Core carries the date, RSS 1.0 has no element of its own
+	Date    string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Content string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+type rdfChannel struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Language    string `xml:"http://purl.org/dc/elements/1.1/ language"`
+	// Polling hints - see api_polling.go
+	UpdatePeriod    string `xml:"http://purl.org/rss/1.0/modules/syndication/ updatePeriod"`
+	UpdateFrequency string `xml:"http://purl.org/rss/1.0/modules/syndication/ updateFrequency"`
+}
+
+type rdfFeed struct {
+	Channel rdfChannel `xml:"channel"`
+	Image   rssImage   `xml:"image"`
+	Items   []rdfItem  `xml:"item"`
+}
+
+func mapRdfItem(item rdfItem) rssItem {
+	return rssItem{
+		Title:       item.Title,
+		Link:        item.Link,
+		PubDate:     item.Date,
+		Guid:        item.About,
+		Description: item.Description,
+		Content:     item.Content,
+	}
+}
+
+// Map onto the RSS structs so processFeed doesn't need to care about the format
+func (feed *rdfFeed) toRss() *rss {
+	channel := rssChannel{
+		Title:           feed.Channel.Title,
+		Link:            feed.Channel.Link,
+		Description:     feed.Channel.Description,
+		Language:        feed.Channel.Language,
+		LastBuildDate:   feed.Channel.Date,
+		Image:           feed.Image,
+		UpdatePeriod:    feed.Channel.UpdatePeriod,
+		UpdateFrequency: feed.Channel.UpdateFrequency,
+	}
+
+	for _, item := range feed.Items {
+		channel.Items = append(channel.Items, mapRdfItem(item))
+	}
+
+	return &rss{
+		Channels: []rssChannel{channel},
+	}
+}
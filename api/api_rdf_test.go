@@ -0,0 +1,67 @@
+package api
+
+import "testing"
+
+const testRdfFeed = `<?xml version="1.0" encoding="utf-8"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmlns="http://purl.org/rss/1.0/"
+	xmlns:dc="http://purl.org/dc/elements/1.1/">
+	<channel rdf:about="https://example.com/">
+		<title>Example</title>
+		<link>https://example.com/</link>
+		<description>An RSS 1.0 feed</description>
+		<items>
+			<rdf:Seq>
+				<rdf:li rdf:resource="https://example.com/a"/>
+				<rdf:li rdf:resource="https://example.com/b"/>
+			</rdf:Seq>
+		</items>
+	</channel>
+	<item rdf:about="https://example.com/a">
+		<title>First</title>
+		<link>https://example.com/a</link>
+		<description>First post</description>
+		<dc:date>2006-01-02T15:04:05Z</dc:date>
+	</item>
+	<item rdf:about="https://example.com/b">
+		<title>Second</title>
+		<link>https://example.com/b</link>
+	</item>
+</rdf:RDF>`
+
+func TestParseFeedRdf(t *testing.T) {
+	if got := detectFeedFormat("application/rdf+xml", []byte(testRdfFeed)); got != formatRdf {
+		t.Fatalf("got format %v, want %v", got, formatRdf)
+	}
+
+	feed, err := parseFeed("application/rdf+xml", []byte(testRdfFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(feed.Channels) != 1 {
+		t.Fatalf("got %v channels, want 1", len(feed.Channels))
+	}
+
+	channel := feed.Channels[0]
+	if channel.Title != "Example" || channel.Link != "https://example.com/" {
+		t.Errorf("got channel %q at %q, want %q at %q", channel.Title, channel.Link, "Example", "https://example.com/")
+	}
+
+	if len(channel.Items) != 2 {
+		t.Fatalf("got %v items, want 2", len(channel.Items))
+	}
+
+	first := channel.Items[0]
+	if first.Title != "First" || first.Link != "https://example.com/a" {
+		t.Errorf("got item %q at %q, want %q at %q", first.Title, first.Link, "First", "https://example.com/a")
+	}
+
+	if first.PubDate != "2006-01-02T15:04:05Z" {
+		t.Errorf("got date %q, want the dc:date", first.PubDate)
+	}
+
+	if first.Guid != "https://example.com/a" {
+		t.Errorf("got GUID %q, want the rdf:about", first.Guid)
+	}
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Body is optional - without it everything up to now is marked
+type markFeedReadRequest struct {
+	Before *time.Time `json:"before"`
+}
+
+type readStateResponse struct {
+	Read    bool  `json:"read"`
+	Updated int64 `json:"updated"`
+}
+
+type unreadCountResponse struct {
+	FeedId      uuid.UUID `json:"feed_id"`
+	FeedName    string    `json:"feed_name"`
+	UnreadCount int64     `json:"unread_count"`
+}
+
+func markPostReadParams(postId uuid.UUID, userId uuid.UUID) database.MarkPostReadParams {
+	params := database.MarkPostReadParams{
+		ReadAt: time.Now().UTC(),
+		PostID: postId,
+		UserID: userId,
+	}
+
+	return params
+}
+
+func markPostUnreadParams(postId uuid.UUID, userId uuid.UUID) database.MarkPostUnreadParams {
+	params := database.MarkPostUnreadParams{
+		UserID: userId,
+		PostID: postId,
+	}
+
+	return params
+}
+
+func markFeedReadParams(feedId uuid.UUID, userId uuid.UUID, before time.Time) database.MarkFeedReadParams {
+	params := database.MarkFeedReadParams{
+		ReadAt: time.Now().UTC(),
+		FeedID: feedId,
+		UserID: userId,
+		Before: before,
+	}
+
+	return params
+}
+
+func markFeedUnreadParams(feedId uuid.UUID, userId uuid.UUID, before time.Time) database.MarkFeedUnreadParams {
+	params := database.MarkFeedUnreadParams{
+		UserID: userId,
+		FeedID: feedId,
+		Before: before,
+	}
+
+	return params
+}
+
+func getIdFromUrl(r *http.Request) (uuid.UUID, error) {
+	providedId := chi.URLParam(r, "id")
+	id, err := uuid.Parse(providedId)
+
+	if err != nil {
+		return uuid.UUID{}, errors.New("id must be a UUID")
+	}
+
+	return id, nil
+}
+
+// Read state can only be set on posts from feeds the user follows
+func (config *ApiConfig) userFollowsPost(ctx context.Context, postId uuid.UUID, userId uuid.UUID) (bool, error) {
+	_, err := config.DbConn.GetPostForUser(ctx, database.GetPostForUserParams{
+		ID:     postId,
+		UserID: userId,
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (config *ApiConfig) userFollowsFeed(ctx context.Context, feedId uuid.UUID, userId uuid.UUID) (bool, error) {
+	_, err := config.DbConn.GetFollowByFeed(ctx, database.GetFollowByFeedParams{
+		UserID: userId,
+		FeedID: feedId,
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Columns have no time zone and are stored as UTC
+func getBeforeFromBody(r *http.Request) (time.Time, error) {
+	decoder := json.NewDecoder(r.Body)
+	requestParams := markFeedReadRequest{}
+	err := decoder.Decode(&requestParams)
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return time.Time{}, err
+	}
+
+	if requestParams.Before == nil {
+		return time.Now().UTC(), nil
+	}
+
+	return requestParams.Before.UTC(), nil
+}
+
+// POST /api/posts/{id}/read
+func (config *ApiConfig) MarkPostRead(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+	postId, err := getIdFromUrl(r)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	followed, err := config.userFollowsPost(r.Context(), postId, user.ID)
+
+	if err != nil {
+		log.Printf("Error retrieving post: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error marking post read")
+		return
+	}
+
+	if !followed {
+		errorResponse(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	updated, err := config.DbConn.MarkPostRead(r.Context(), markPostReadParams(postId, user.ID))
+
+	if err != nil {
+		log.Printf("Error marking post read: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error marking post read")
+		return
+	}
+
+	validResponse(w, http.StatusOK, readStateResponse{
+		Read:    true,
+		Updated: updated,
+	})
+	return
+}
+
+// DELETE /api/posts/{id}/read
+func (config *ApiConfig) MarkPostUnread(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+	postId, err := getIdFromUrl(r)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	followed, err := config.userFollowsPost(r.Context(), postId, user.ID)
+
+	if err != nil {
+		log.Printf("Error retrieving post: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error marking post unread")
+		return
+	}
+
+	if !followed {
+		errorResponse(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	updated, err := config.DbConn.MarkPostUnread(r.Context(), markPostUnreadParams(postId, user.ID))
+
+	if err != nil {
+		log.Printf("Error marking post unread: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error marking post unread")
+		return
+	}
+
+	validResponse(w, http.StatusOK, readStateResponse{
+		Read:    false,
+		Updated: updated,
+	})
+	return
+}
+
+// POST /api/feeds/{id}/read
+func (config *ApiConfig) MarkFeedRead(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+	feedId, err := getIdFromUrl(r)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	before, err := getBeforeFromBody(r)
+
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	followed, err := config.userFollowsFeed(r.Context(), feedId, user.ID)
+
+	if err != nil {
+		log.Printf("Error retrieving follow: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error marking feed read")
+		return
+	}
+
+	if !followed {
+		errorResponse(w, http.StatusNotFound, "Feed not found")
+		return
+	}
+
+	updated, err := config.DbConn.MarkFeedRead(r.Context(), markFeedReadParams(feedId, user.ID, before))
+
+	if err != nil {
+		log.Printf("Error marking feed read: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error marking feed read")
+		return
+	}
+
+	validResponse(w, http.StatusOK, readStateResponse{
+		Read:    true,
+		Updated: updated,
+	})
+	return
+}
+
+// DELETE /api/feeds/{id}/read
+func (config *ApiConfig) MarkFeedUnread(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+	feedId, err := getIdFromUrl(r)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	before, err := getBeforeFromBody(r)
+
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	followed, err := config.userFollowsFeed(r.Context(), feedId, user.ID)
+
+	if err != nil {
+		log.Printf("Error retrieving follow: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error marking feed unread")
+		return
+	}
+
+	if !followed {
+		errorResponse(w, http.StatusNotFound, "Feed not found")
+		return
+	}
+
+	updated, err := config.DbConn.MarkFeedUnread(r.Context(), markFeedUnreadParams(feedId, user.ID, before))
+
+	if err != nil {
+		log.Printf("Error marking feed unread: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error marking feed unread")
+		return
+	}
+
+	validResponse(w, http.StatusOK, readStateResponse{
+		Read:    false,
+		Updated: updated,
+	})
+	return
+}
+
+// GET /api/follows/unread
+func (config *ApiConfig) GetUnreadCounts(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+
+	counts, err := config.DbConn.GetUnreadCounts(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error retrieving unread counts: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error retrieving unread counts")
+		return
+	}
+
+	returnedCounts := []unreadCountResponse{}
+
+	for _, count := range counts {
+		returnedCounts = append(returnedCounts, unreadCountResponse{
+			FeedId:      count.FeedID,
+			FeedName:    count.FeedName,
+			UnreadCount: count.UnreadCount,
+		})
+	}
+
+	validResponse(w, http.StatusOK, returnedCounts)
+	return
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+)
+
+// Manual refreshes share the scheduler's lease, so they never overlap a
+// scheduled fetch of the same feed on any instance
+
+// Feeds can't be refreshed again within this long of their last fetch
+const manualRefreshCooldown = time.Minute
+const manualRefreshTimeout = 30 * time.Second
+const manualRefreshLease = 5 * time.Minute
+
+type refreshResponse struct {
+	Status       *int32   `json:"status"`
+	NotModified  bool     `json:"not_modified"`
+	ItemsSeen    int      `json:"items_seen"`
+	PostsCreated int      `json:"posts_created"`
+	PostsUpdated int      `json:"posts_updated"`
+	ParseErrors  []string `json:"parse_errors"`
+	Error        *string  `json:"error"`
+}
+
+func mapRefreshResponse(outcome refreshOutcome) refreshResponse {
+	response := refreshResponse{
+		ItemsSeen:    outcome.Summary.ItemsSeen,
+		PostsCreated: outcome.Summary.PostsCreated,
+		PostsUpdated: outcome.Summary.PostsUpdated,
+		ParseErrors:  outcome.Summary.ParseErrors,
+	}
+
+	if response.ParseErrors == nil {
+		response.ParseErrors = []string{}
+	}
+
+	// What went wrong upstream is only logged, status included
+	if outcome.Err != nil {
+		message := "Could not refresh feed"
+		response.Error = &message
+		return response
+	}
+
+	if outcome.Result != nil {
+		response.Status = nullInt32Response(nullableStatus(outcome.Result.StatusCode))
+		response.NotModified = outcome.Result.NotModified
+	}
+
+	return response
+}
+
+// Zero once the feed can be refreshed again. LastFetchedAt is written as
+// UTC by the fetch queries and read back as UTC, so now must be UTC too
+func refreshCooldown(feed database.Feed, now time.Time) time.Duration {
+	if !feed.LastFetchedAt.Valid {
+		return 0
+	}
+
+	return max(feed.LastFetchedAt.Time.Add(manualRefreshCooldown).Sub(now), 0)
+}
+
+// POST /api/feeds/{id}/refresh
+func (config *ApiConfig) RefreshFeed(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+
+	feedId, err := getIdFromUrl(r)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Only followers can refresh a feed
+	_, err = config.DbConn.GetFollowByFeed(r.Context(), database.GetFollowByFeedParams{
+		UserID: user.ID,
+		FeedID: feedId,
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		errorResponse(w, http.StatusNotFound, "Feed not found")
+		return
+	}
+
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Error retrieving feed")
+		return
+	}
+
+	feed, err := config.DbConn.GetFeedById(r.Context(), feedId)
+
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Error retrieving feed")
+		return
+	}
+
+	if wait := refreshCooldown(feed, time.Now().UTC()); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		errorResponse(w, http.StatusTooManyRequests, "Feed was fetched recently")
+		return
+	}
+
+	feed, err = config.DbConn.ClaimFeed(r.Context(), database.ClaimFeedParams{
+		LeaseSeconds: int32(manualRefreshLease.Seconds()),
+		ID:           feedId,
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		errorResponse(w, http.StatusConflict, "Feed is already being fetched")
+		return
+	}
+
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Error claiming feed")
+		return
+	}
+
+	// Finish even if the client goes away, so the lease is released
+	outcome := config.refreshFeed(context.WithoutCancel(r.Context()), feed, manualRefreshTimeout)
+	log.Printf("Manual refresh of %v by %v", feed.Url, user.ID)
+
+	validResponse(w, http.StatusOK, mapRefreshResponse(outcome))
+	return
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"html"
+	"net/url"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Feed HTML is untrusted. Everything goes through an allowlist before it's
+// stored, so the API only ever serves markup that's safe to render as-is
+
+const excerptLength = 300
+
+// Elements kept as-is (minus any attributes not listed below)
+var allowedElements = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.B: true, atom.Blockquote: true, atom.Br: true,
+	atom.Caption: true, atom.Cite: true, atom.Code: true, atom.Dd: true, atom.Del: true,
+	atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Em: true, atom.Figcaption: true,
+	atom.Figure: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+	atom.H5: true, atom.H6: true, atom.Hr: true, atom.I: true, atom.Img: true,
+	atom.Ins: true, atom.Kbd: true, atom.Li: true, atom.Mark: true, atom.Ol: true,
+	atom.P: true, atom.Pre: true, atom.Q: true, atom.S: true, atom.Small: true,
+	atom.Span: true, atom.Strong: true, atom.Sub: true, atom.Sup: true, atom.Table: true,
+	atom.Tbody: true, atom.Td: true, atom.Tfoot: true, atom.Th: true, atom.Thead: true,
+	atom.Time: true, atom.Tr: true, atom.U: true, atom.Ul: true,
+}
+
+// Elements dropped along with everything inside them.
+// Anything else unknown is unwrapped and its children kept
+var droppedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Iframe: true, atom.Object: true,
+	atom.Embed: true, atom.Noscript: true, atom.Template: true, atom.Svg: true,
+	atom.Math: true, atom.Form: true, atom.Input: true, atom.Button: true,
+	atom.Textarea: true, atom.Select: true, atom.Frame: true, atom.Frameset: true,
+	atom.Head: true, atom.Title: true, atom.Meta: true, atom.Link: true, atom.Base: true,
+}
+
+// Event handlers, style and the like never make it through
+var allowedAttributes = map[string]bool{
+	"href": true, "src": true, "alt": true, "title": true, "width": true,
+	"height": true, "colspan": true, "rowspan": true, "cite": true,
+	"datetime": true, "lang": true, "dir": true,
+}
+
+var urlAttributes = map[string]bool{
+	"href": true, "src": true, "cite": true,
+}
+
+// Elements that break up text when building the plain-text excerpt
+var blockElements = map[atom.Atom]bool{
+	atom.Blockquote: true, atom.Br: true, atom.Dd: true, atom.Div: true, atom.Dt: true,
+	atom.Figcaption: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+	atom.H5: true, atom.H6: true, atom.Hr: true, atom.Li: true, atom.P: true,
+	atom.Pre: true, atom.Td: true, atom.Th: true, atom.Tr: true,
+}
+
+// Relative URLs are resolved against base. Anything that isn't http(s)
+// (or mailto for links) is dropped - that covers javascript:, data: and friends
+func sanitiseUrl(raw string, base *url.URL, allowMailto bool) (string, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+
+	if err != nil {
+		return "", false
+	}
+
+	if base != nil {
+		parsed = base.ResolveReference(parsed)
+	}
+
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		return parsed.String(), true
+	case "mailto":
+		return parsed.String(), allowMailto
+	default:
+		return "", false
+	}
+}
+
+func sanitiseAttributes(node *nethtml.Node, base *url.URL) []nethtml.Attribute {
+	var attributes []nethtml.Attribute
+
+	for _, attribute := range node.Attr {
+		key := strings.ToLower(attribute.Key)
+
+		if attribute.Namespace != "" || !allowedAttributes[key] {
+			continue
+		}
+
+		value := attribute.Val
+		if urlAttributes[key] {
+			safeUrl, ok := sanitiseUrl(value, base, node.DataAtom == atom.A)
+			if !ok {
+				continue
+			}
+			value = safeUrl
+		}
+
+		attributes = append(attributes, nethtml.Attribute{Key: key, Val: value})
+	}
+
+	// Links open away from the client and don't leak it as the referrer
+	if node.DataAtom == atom.A {
+		attributes = append(attributes, nethtml.Attribute{Key: "rel", Val: "nofollow noopener noreferrer"})
+	}
+
+	return attributes
+}
+
+// Copies the allowed parts of node under dst
+func sanitiseNode(dst *nethtml.Node, node *nethtml.Node, base *url.URL) {
+	switch node.Type {
+	case nethtml.TextNode:
+		dst.AppendChild(&nethtml.Node{Type: nethtml.TextNode, Data: node.Data})
+	case nethtml.ElementNode:
+		if droppedElements[node.DataAtom] {
+			return
+		}
+
+		if !allowedElements[node.DataAtom] {
+			sanitiseChildren(dst, node, base)
+			return
+		}
+
+		element := &nethtml.Node{
+			Type:     nethtml.ElementNode,
+			Data:     node.DataAtom.String(),
+			DataAtom: node.DataAtom,
+			Attr:     sanitiseAttributes(node, base),
+		}
+
+		// Images without a usable source are pointless
+		if node.DataAtom == atom.Img && !hasAttribute(element, "src") {
+			return
+		}
+
+		sanitiseChildren(element, node, base)
+		dst.AppendChild(element)
+	}
+}
+
+func sanitiseChildren(dst *nethtml.Node, src *nethtml.Node, base *url.URL) {
+	for child := src.FirstChild; child != nil; child = child.NextSibling {
+		sanitiseNode(dst, child, base)
+	}
+}
+
+func hasAttribute(node *nethtml.Node, key string) bool {
+	for _, attribute := range node.Attr {
+		if attribute.Key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseHtmlFragment(raw string) ([]*nethtml.Node, error) {
+	context := &nethtml.Node{
+		Type:     nethtml.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	}
+
+	return nethtml.ParseFragment(strings.NewReader(raw), context)
+}
+
+// Returns a cleaned copy of raw, with relative links resolved against base
+func sanitiseHtml(raw string, base *url.URL) string {
+	nodes, err := parseHtmlFragment(raw)
+
+	if err != nil {
+		return html.EscapeString(raw)
+	}
+
+	root := &nethtml.Node{Type: nethtml.DocumentNode}
+	for _, node := range nodes {
+		sanitiseNode(root, node, base)
+	}
+
+	var rendered strings.Builder
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		nethtml.Render(&rendered, child)
+	}
+
+	return strings.TrimSpace(rendered.String())
+}
+
+// Plain text gets escaped, with blank lines as paragraph breaks
+func plainTextToHtml(text string) string {
+	var paragraphs []string
+
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+
+		escaped := html.EscapeString(paragraph)
+		paragraphs = append(paragraphs, "<p>"+strings.ReplaceAll(escaped, "\n", "<br/>")+"</p>")
+	}
+
+	return strings.Join(paragraphs, "\n")
+}
+
+func collectText(builder *strings.Builder, node *nethtml.Node) {
+	switch node.Type {
+	case nethtml.TextNode:
+		builder.WriteString(node.Data)
+		return
+	case nethtml.ElementNode:
+		if droppedElements[node.DataAtom] {
+			return
+		}
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		collectText(builder, child)
+	}
+
+	if blockElements[node.DataAtom] {
+		builder.WriteString(" ")
+	}
+}
+
+// Strips markup and collapses whitespace
+func htmlToText(raw string) string {
+	nodes, err := parseHtmlFragment(raw)
+
+	if err != nil {
+		return strings.Join(strings.Fields(raw), " ")
+	}
+
+	var builder strings.Builder
+	for _, node := range nodes {
+		collectText(&builder, node)
+	}
+
+	return strings.Join(strings.Fields(builder.String()), " ")
+}
+
+// Cuts at a word boundary where possible
+func truncateText(text string, length int) string {
+	runes := []rune(text)
+
+	if len(runes) <= length {
+		return text
+	}
+
+	cut := string(runes[:length])
+	if lastSpace := strings.LastIndex(cut, " "); lastSpace > length/2 {
+		cut = cut[:lastSpace]
+	}
+
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// Links in the post resolve against the post itself, falling back to the channel then the feed
+func postBaseUrl(item rssItem, channel rssChannel, feedUrl string) *url.URL {
+	base, err := url.Parse(feedUrl)
+
+	if err != nil {
+		return nil
+	}
+
+	// Only links that pass sanitiseUrl - a javascript: base would make every
+	// relative link in the post a javascript: one
+	for _, candidate := range []string{channel.Link, item.Link} {
+		if candidate == "" {
+			continue
+		}
+
+		safeUrl, ok := sanitiseUrl(candidate, base, false)
+		if !ok {
+			continue
+		}
+
+		resolved, err := url.Parse(safeUrl)
+		if err == nil {
+			base = resolved
+		}
+	}
+
+	return base
+}
+
+// Runs between fetching and storing: every post ends up with safe HTML for its
+// description and content, plus a plain-text excerpt.
+// A link that isn't http(s) is dropped, leaving the post without one
+func sanitiseItem(item rssItem, base *url.URL) rssItem {
+	if item.Link != "" {
+		safeUrl, ok := sanitiseUrl(item.Link, base, false)
+		if !ok {
+			safeUrl = ""
+		}
+		item.Link = safeUrl
+	}
+
+	if item.ContentType == contentTypeText {
+		item.Content = plainTextToHtml(item.Content)
+	} else {
+		item.Content = sanitiseHtml(item.Content, base)
+	}
+	item.ContentType = contentTypeHtml
+
+	item.Description = sanitiseHtml(item.Description, base)
+
+	excerptSource := item.Description
+	if excerptSource == "" {
+		excerptSource = item.Content
+	}
+	item.Excerpt = truncateText(htmlToText(excerptSource), excerptLength)
+
+	return item
+}
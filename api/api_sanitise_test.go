@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestSanitiseHtml(t *testing.T) {
+	base, _ := url.Parse("https://example.com/posts/1")
+
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"plain markup kept", `<p>Hello <strong>world</strong></p>`, `<p>Hello <strong>world</strong></p>`},
+		{"script removed", `<p>Hi</p><script>alert(1)</script>`, `<p>Hi</p>`},
+		{"script inside allowed element", `<p>Hi<script>alert(1)</script></p>`, `<p>Hi</p>`},
+		{"style removed", `<style>body { display: none }</style><p>Hi</p>`, `<p>Hi</p>`},
+		{"style attribute removed", `<p style="color: red">Hi</p>`, `<p>Hi</p>`},
+		{"iframe removed", `<iframe src="https://example.com"></iframe><p>Hi</p>`, `<p>Hi</p>`},
+		{"unknown element unwrapped", `<article><p>Hi</p></article>`, `<p>Hi</p>`},
+		{"onclick removed", `<p onclick="alert(1)">Hi</p>`, `<p>Hi</p>`},
+		{"onerror removed", `<img src="https://example.com/a.png" onerror="alert(1)">`, `<img src="https://example.com/a.png"/>`},
+		{"on attribute mixed case", `<p OnMouseOver="alert(1)">Hi</p>`, `<p>Hi</p>`},
+		{"relative href resolved", `<a href="/about">About</a>`, `<a href="https://example.com/about" rel="nofollow noopener noreferrer">About</a>`},
+		{"mailto href kept", `<a href="mailto:me@example.com">Mail</a>`, `<a href="mailto:me@example.com" rel="nofollow noopener noreferrer">Mail</a>`},
+		{"mailto src dropped", `<img src="mailto:me@example.com">`, ``},
+		{"javascript href", `<a href="javascript:alert(1)">Hi</a>`, `<a rel="nofollow noopener noreferrer">Hi</a>`},
+		{"javascript href mixed case", `<a href="JaVaScRiPt:alert(1)">Hi</a>`, `<a rel="nofollow noopener noreferrer">Hi</a>`},
+		{"javascript href leading space", `<a href="  javascript:alert(1)">Hi</a>`, `<a rel="nofollow noopener noreferrer">Hi</a>`},
+		{"javascript href leading newline", `<a href="&#x0A;javascript:alert(1)">Hi</a>`, `<a rel="nofollow noopener noreferrer">Hi</a>`},
+		{"javascript href with tab", `<a href="java&#x09;script:alert(1)">Hi</a>`, `<a rel="nofollow noopener noreferrer">Hi</a>`},
+		{"javascript href entity encoded", `<a href="jav&#x61;script:alert(1)">Hi</a>`, `<a rel="nofollow noopener noreferrer">Hi</a>`},
+		{"javascript href named entity", `<a href="javascript&colon;alert(1)">Hi</a>`, `<a rel="nofollow noopener noreferrer">Hi</a>`},
+		{"javascript src", `<img src="javascript:alert(1)">`, ``},
+		{"data src", `<img src="data:image/svg+xml;base64,PHN2Zz48L3N2Zz4=">`, ``},
+		{"data src mixed case", `<img src="DaTa:image/png;base64,AAAA">`, ``},
+		{"data href", `<a href="data:text/html,<script>alert(1)</script>">Hi</a>`, `<a rel="nofollow noopener noreferrer">Hi</a>`},
+		{"vbscript href", `<a href="vbscript:msgbox(1)">Hi</a>`, `<a rel="nofollow noopener noreferrer">Hi</a>`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sanitiseHtml(test.raw, base); got != test.want {
+				t.Errorf("sanitiseHtml(%q) = %q, want %q", test.raw, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSanitiseItemLink(t *testing.T) {
+	base, _ := url.Parse("https://example.com/feed.xml")
+
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{"absolute link kept", "https://example.com/a", "https://example.com/a"},
+		{"relative link resolved", "/a", "https://example.com/a"},
+		{"javascript link blanked", "javascript:alert(1)", ""},
+		{"javascript link mixed case blanked", " JavaScript:alert(1)", ""},
+		{"data link blanked", "data:text/html,hi", ""},
+		{"mailto link blanked", "mailto:me@example.com", ""},
+		{"no link", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			item := sanitiseItem(rssItem{Link: test.link}, base)
+			if item.Link != test.want {
+				t.Errorf("got link %q, want %q", item.Link, test.want)
+			}
+		})
+	}
+}
+
+func TestPostBaseUrl(t *testing.T) {
+	const feedUrl = "https://example.com/feed.xml"
+
+	tests := []struct {
+		name        string
+		itemLink    string
+		channelLink string
+		want        string
+	}{
+		{"item link", "https://blog.example.com/posts/1", "https://blog.example.com", "https://blog.example.com/posts/1"},
+		{"channel link", "", "https://blog.example.com/", "https://blog.example.com/"},
+		{"feed url", "", "", feedUrl},
+		{"relative item link", "/posts/1", "", "https://example.com/posts/1"},
+		{"javascript item link ignored", "javascript:alert(1)", "https://blog.example.com/", "https://blog.example.com/"},
+		{"data channel link ignored", "", "data:text/html,hi", feedUrl},
+		{"unsafe links ignored", "JAVASCRIPT:alert(1)", "javascript:alert(2)", feedUrl},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := postBaseUrl(rssItem{Link: test.itemLink}, rssChannel{Link: test.channelLink}, feedUrl)
+			if base == nil || base.String() != test.want {
+				t.Errorf("got base %v, want %v", base, test.want)
+			}
+		})
+	}
+}
+
+// Relative links in a post with a javascript: link must not pick up its scheme
+func TestSanitiseItemUnsafeBase(t *testing.T) {
+	item := rssItem{
+		Link:        "javascript:alert(1)",
+		Description: `<a href="x">Hi</a>`,
+	}
+
+	base := postBaseUrl(item, rssChannel{}, "https://example.com/feed.xml")
+	item = sanitiseItem(item, base)
+
+	want := `<a href="https://example.com/x" rel="nofollow noopener noreferrer">Hi</a>`
+	if item.Description != want {
+		t.Errorf("got description %q, want %q", item.Description, want)
+	}
+
+	if item.Link != "" {
+		t.Errorf("got link %q, want it blanked", item.Link)
+	}
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+	"github.com/google/uuid"
+)
+
+type SchedulerConfig struct {
+	Interval time.Duration
+	// Most feeds claimed at once
+	BatchSize int
+	// Timeout for each feed's HTTP request
+	FetchTimeout time.Duration
+	Workers      int
+	// Most fetches running against one host at once
+	PerHostLimit int
+	// How long a claimed feed stays off limits to other instances
+	LeaseDuration time.Duration
+}
+
+// Periodically queues the feeds that are due for a fixed pool of workers,
+// and again whenever a worker frees up while there's a backlog. The loop
+// never waits on the workers, so a slow host only ties up the workers
+// fetching from it.
+// Feeds are leased in the database before they're queued, so any number of
+// instances can run a scheduler without fetching the same feed twice. Only
+// as many are leased as there are idle workers, so a lease never runs down
+// while its feed waits in the queue
+type Scheduler struct {
+	api      *ApiConfig
+	settings SchedulerConfig
+	queue    chan database.Feed
+	// Signalled when a worker frees up
+	idle chan struct{}
+
+	mu sync.Mutex
+	// Feeds queued or being fetched, so the next tick doesn't queue them again
+	pending map[uuid.UUID]bool
+	// Fetches running per host
+	hosts map[string]int
+}
+
+func NewScheduler(config *ApiConfig, settings SchedulerConfig) (*Scheduler, error) {
+	if settings.Interval <= 0 {
+		return nil, errors.New("fetch interval must be positive")
+	}
+
+	if settings.BatchSize <= 0 {
+		return nil, errors.New("fetch batch size must be positive")
+	}
+
+	if settings.FetchTimeout <= 0 {
+		return nil, errors.New("fetch timeout must be positive")
+	}
+
+	if settings.Workers <= 0 {
+		return nil, errors.New("fetch workers must be positive")
+	}
+
+	if settings.PerHostLimit <= 0 {
+		return nil, errors.New("fetch per-host limit must be positive")
+	}
+
+	if settings.LeaseDuration < settings.FetchTimeout {
+		return nil, errors.New("fetch lease must be at least the fetch timeout")
+	}
+
+	return &Scheduler{
+		api:      config,
+		settings: settings,
+		queue:    make(chan database.Feed, settings.Workers),
+		idle:     make(chan struct{}, 1),
+		pending:  map[uuid.UUID]bool{},
+		hosts:    map[string]int{},
+	}, nil
+}
+
+// Blocks until ctx is cancelled. Fetches already running are allowed to
+// finish, so this only returns once they have drained
+func (scheduler *Scheduler) FetchLoop(ctx context.Context) {
+	ticker := time.NewTicker(scheduler.settings.Interval)
+	defer ticker.Stop()
+
+	log.Printf("Init fetch loop: every %v, %v workers, %v per host", scheduler.settings.Interval, scheduler.settings.Workers, scheduler.settings.PerHostLimit)
+
+	var workers sync.WaitGroup
+	for i := 0; i < scheduler.settings.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			scheduler.work(ctx)
+		}()
+	}
+
+	// Whether the last claim might have left due feeds behind
+	backlog := false
+
+	for {
+		// Block until a signal is received from the ticker, or a worker frees up
+		select {
+		case <-ctx.Done():
+			close(scheduler.queue)
+			workers.Wait()
+			log.Printf("Fetch loop stopped")
+			return
+		case <-ticker.C:
+			backlog = scheduler.enqueueDue(ctx)
+		case <-scheduler.idle:
+			if backlog {
+				backlog = scheduler.enqueueDue(ctx)
+			}
+		}
+	}
+}
+
+// Claims feeds for the idle workers. True if there may be more due
+func (scheduler *Scheduler) enqueueDue(ctx context.Context) bool {
+	limit := min(scheduler.settings.BatchSize, scheduler.idleWorkers())
+
+	if limit <= 0 {
+		return true
+	}
+
+	feeds, err := scheduler.api.DbConn.ClaimFeedsToFetch(ctx, database.ClaimFeedsToFetchParams{
+		LeaseSeconds: int32(scheduler.settings.LeaseDuration.Seconds()),
+		Limit:        int32(limit),
+	})
+
+	if err != nil {
+		log.Printf("Error: failed to retrieve feeds to fetch: %v", err)
+		return false
+	}
+
+	queued := 0
+	for i, feed := range feeds {
+		// Lease ran out while it was still being fetched here - it's already covered
+		if !scheduler.claim(feed.ID) {
+			continue
+		}
+
+		select {
+		case scheduler.queue <- feed:
+			queued++
+		default:
+			// Claims are capped at the idle workers, so this is only a
+			// safety net - hand the rest back so they're due next tick
+			scheduler.release(ctx, feed)
+			for _, unqueued := range feeds[i+1:] {
+				if scheduler.claim(unqueued.ID) {
+					scheduler.release(ctx, unqueued)
+				}
+			}
+			log.Printf("Fetch queue full, queued %v of %v due feeds", queued, len(feeds))
+			return true
+		}
+	}
+
+	if queued > 0 {
+		log.Printf("Queued %v feeds", queued)
+	}
+
+	return len(feeds) == limit
+}
+
+func (scheduler *Scheduler) work(ctx context.Context) {
+	for feed := range scheduler.queue {
+		// Shutting down - leave the rest of the queue for next time
+		if ctx.Err() != nil {
+			scheduler.release(context.WithoutCancel(ctx), feed)
+			continue
+		}
+
+		host := feedHost(feed.Url)
+		if !scheduler.acquireHost(host) {
+			// Picked up again on a later tick rather than holding a worker
+			scheduler.deferFetch(ctx, feed)
+			continue
+		}
+
+		// In-flight fetches finish even if we're asked to stop.
+		// Recording the outcome clears the lease
+		scheduler.api.refreshFeed(context.WithoutCancel(ctx), feed, scheduler.settings.FetchTimeout)
+
+		scheduler.releaseHost(host)
+		scheduler.done(feed.ID)
+	}
+}
+
+// Every pending feed is either being fetched or about to be picked up
+func (scheduler *Scheduler) idleWorkers() int {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	return scheduler.settings.Workers - len(scheduler.pending)
+}
+
+func (scheduler *Scheduler) claim(feedId uuid.UUID) bool {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	if scheduler.pending[feedId] {
+		return false
+	}
+
+	scheduler.pending[feedId] = true
+	return true
+}
+
+func (scheduler *Scheduler) done(feedId uuid.UUID) {
+	scheduler.mu.Lock()
+	delete(scheduler.pending, feedId)
+	scheduler.mu.Unlock()
+
+	// One signal is enough for the loop to claim for every idle worker
+	select {
+	case scheduler.idle <- struct{}{}:
+	default:
+	}
+}
+
+// Gives up a feed without fetching it, so any instance can claim it straight away
+func (scheduler *Scheduler) release(ctx context.Context, feed database.Feed) {
+	scheduler.done(feed.ID)
+
+	err := scheduler.api.DbConn.ReleaseFeedLease(ctx, database.ReleaseFeedLeaseParams{
+		ID:          feed.ID,
+		LockedUntil: feed.LockedUntil,
+	})
+	if err != nil {
+		log.Printf("Error: failed to release lease on feed %v: %v", feed.ID, err)
+	}
+}
+
+// Like release, but the feed isn't due again until the next tick. Feeds on a
+// busy host would otherwise be claimed first every time, ahead of the rest
+func (scheduler *Scheduler) deferFetch(ctx context.Context, feed database.Feed) {
+	scheduler.done(feed.ID)
+
+	err := scheduler.api.DbConn.DeferFeedFetch(ctx, database.DeferFeedFetchParams{
+		DelaySeconds: max(int32(scheduler.settings.Interval.Seconds()), 1),
+		ID:           feed.ID,
+		LockedUntil:  feed.LockedUntil,
+	})
+	if err != nil {
+		log.Printf("Error: failed to defer feed %v: %v", feed.ID, err)
+	}
+}
+
+func (scheduler *Scheduler) acquireHost(host string) bool {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	if scheduler.hosts[host] >= scheduler.settings.PerHostLimit {
+		return false
+	}
+
+	scheduler.hosts[host]++
+	return true
+}
+
+func (scheduler *Scheduler) releaseHost(host string) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	scheduler.hosts[host]--
+	if scheduler.hosts[host] <= 0 {
+		delete(scheduler.hosts, host)
+	}
+}
+
+// Unparseable URLs share a bucket - the fetch will fail quickly anyway
+func feedHost(feedUrl string) string {
+	parsed, err := url.Parse(feedUrl)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(parsed.Hostname())
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Each route needs one scope, given when it's registered in getApiRouterV1.
+// A key with the wildcard scope can do anything, and is what users are
+// given when they sign up
+
+const (
+	ScopeAll          = "*"
+	ScopeUsersRead    = "users:read"
+	ScopeUsersWrite   = "users:write"
+	ScopeFeedsWrite   = "feeds:write"
+	ScopeFollowsRead  = "follows:read"
+	ScopeFollowsWrite = "follows:write"
+	ScopePostsRead    = "posts:read"
+	ScopePostsWrite   = "posts:write"
+	ScopeKeysRead     = "keys:read"
+	ScopeKeysWrite    = "keys:write"
+)
+
+var knownScopes = []string{
+	ScopeAll,
+	ScopeUsersRead,
+	ScopeUsersWrite,
+	ScopeFeedsWrite,
+	ScopeFollowsRead,
+	ScopeFollowsWrite,
+	ScopePostsRead,
+	ScopePostsWrite,
+	ScopeKeysRead,
+	ScopeKeysWrite,
+}
+
+type scopesContextKey struct{}
+
+func hasScope(granted []string, scope string) bool {
+	return slices.Contains(granted, ScopeAll) || slices.Contains(granted, scope)
+}
+
+// Defaults to everything if none are given. Duplicates are dropped
+func validateScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return []string{ScopeAll}, nil
+	}
+
+	var scopes []string
+	for _, scope := range requested {
+		scope = strings.TrimSpace(scope)
+
+		if !slices.Contains(knownScopes, scope) {
+			return nil, fmt.Errorf("unknown scope: %v", scope)
+		}
+
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+// First scope in requested that granted doesn't cover, if any
+func missingScope(granted []string, requested []string) (string, bool) {
+	for _, scope := range requested {
+		if !hasScope(granted, scope) {
+			return scope, true
+		}
+	}
+
+	return "", false
+}
+
+func withScopes(r *http.Request, scopes []string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), scopesContextKey{}, scopes))
+}
+
+// Scopes of the key or session that authenticated the request
+func requestScopes(r *http.Request) []string {
+	scopes, _ := r.Context().Value(scopesContextKey{}).([]string)
+	return scopes
+}
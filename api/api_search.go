@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+	"github.com/google/uuid"
+)
+
+type searchResultResponse struct {
+	postResponse
+	Rank    float32 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+type searchResultList struct {
+	Posts      []searchResultResponse `json:"posts"`
+	NextCursor *string                `json:"next_cursor"`
+}
+
+// Snippets come back from ts_headline with <mark> around matches but are
+// otherwise raw post text, so escape everything except the markers
+var snippetMarkers = strings.NewReplacer(
+	html.EscapeString("<mark>"), "<mark>",
+	html.EscapeString("</mark>"), "</mark>",
+)
+
+func safeSnippet(snippet string) string {
+	return snippetMarkers.Replace(html.EscapeString(snippet))
+}
+
+// Query params: q, limit, cursor
+// Supports web search syntax, e.g. "go generics" -rust or postgres
+// One extra row is requested to tell whether there's another page
+func searchPostsForUserParams(userId uuid.UUID, query url.Values) (database.SearchPostsForUserParams, error) {
+	searchQuery := strings.TrimSpace(query.Get("q"))
+
+	if searchQuery == "" {
+		return database.SearchPostsForUserParams{}, errors.New("q is required")
+	}
+
+	limit, err := getPageLimit(query)
+
+	if err != nil {
+		return database.SearchPostsForUserParams{}, err
+	}
+
+	params := database.SearchPostsForUserParams{
+		Query:  searchQuery,
+		UserID: userId,
+		Limit:  int32(limit + 1),
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		decoded, err := decodeSearchCursor(cursor)
+
+		if err != nil {
+			return database.SearchPostsForUserParams{}, err
+		}
+
+		params.CursorRank = sql.NullFloat64{
+			Float64: float64(decoded.Rank),
+			Valid:   true,
+		}
+		params.CursorID = nullUuidParam(&decoded.Id)
+	}
+
+	return params, nil
+}
+
+func mapSearchResultList(results []database.SearchPostsForUserRow, limit int) searchResultList {
+	response := searchResultList{
+		Posts: []searchResultResponse{},
+	}
+
+	if len(results) > limit {
+		results = results[:limit]
+		last := results[len(results)-1]
+		nextCursor := encodeSearchCursor(last.Rank, last.ID)
+		response.NextCursor = &nextCursor
+	}
+
+	for _, result := range results {
+		response.Posts = append(response.Posts, searchResultResponse{
+			postResponse: postResponse{
+				Id:          result.ID,
+				CreatedAt:   result.CreatedAt,
+				UpdatedAt:   result.UpdatedAt,
+				Title:       result.Title,
+				Url:         result.Url,
+				Description: result.Description.String,
+				Excerpt:     result.Excerpt.String,
+				PublishedAt: result.PublishedAt,
+				FeedID:      result.FeedID,
+				FeedName:    result.FeedName,
+				FeedUrl:     result.FeedUrl,
+				Read:        result.IsRead,
+			},
+			Rank:    result.Rank,
+			Snippet: safeSnippet(result.Snippet),
+		})
+	}
+
+	return response
+}
+
+// GET /api/posts/search
+func (config *ApiConfig) SearchPostsForUser(w http.ResponseWriter, r *http.Request, user database.User) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params, err := searchPostsForUserParams(user.ID, r.URL.Query())
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, err := config.DbConn.SearchPostsForUser(context.TODO(), params)
+
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Error searching posts")
+		return
+	}
+
+	validResponse(w, http.StatusOK, mapSearchResultList(results, int(params.Limit)-1))
+	return
+}
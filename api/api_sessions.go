@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ajpotts01/go-blog-aggregator/internal/database"
+	"github.com/google/uuid"
+)
+
+// Sessions are for the web frontend: logging in sets an HttpOnly cookie
+// holding a random token, which AuthMiddleware accepts in place of an API
+// key. SameSite=Lax keeps other sites from making requests with it.
+// Sessions can do anything the user can
+
+const sessionCookieName = "session"
+const sessionLifetime = 30 * 24 * time.Hour
+
+var sessionScopes = []string{ScopeAll}
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type sessionContextKey struct{}
+
+func (config *ApiConfig) setSessionCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
+		HttpOnly: true,
+		Secure:   config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (config *ApiConfig) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Returns the new session and the raw token for its cookie
+func (config *ApiConfig) createSession(ctx context.Context, userId uuid.UUID) (database.Session, string, error) {
+	newId, err := uuid.NewUUID()
+
+	if err != nil {
+		return database.Session{}, "", err
+	}
+
+	token, err := generateToken()
+
+	if err != nil {
+		return database.Session{}, "", err
+	}
+
+	createdAt := time.Now().UTC()
+
+	session, err := config.DbConn.CreateSession(ctx, database.CreateSessionParams{
+		ID:        newId,
+		CreatedAt: createdAt,
+		UserID:    userId,
+		TokenHash: hashToken(token),
+		ExpiresAt: createdAt.Add(sessionLifetime),
+	})
+
+	return session, token, err
+}
+
+// Creates a session and sets its cookie. Expired sessions are tidied up first
+func (config *ApiConfig) startSession(ctx context.Context, w http.ResponseWriter, userId uuid.UUID) error {
+	err := config.DbConn.DeleteExpiredSessions(ctx, userId)
+	if err != nil {
+		log.Printf("Error clearing expired sessions for %v: %v", userId, err)
+	}
+
+	session, token, err := config.createSession(ctx, userId)
+
+	if err != nil {
+		return err
+	}
+
+	config.setSessionCookie(w, token, session.ExpiresAt)
+	return nil
+}
+
+func withSession(r *http.Request, sessionId uuid.UUID) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, sessionId))
+}
+
+// Session the request was made with. False for requests made with an API key
+func requestSession(r *http.Request) (uuid.UUID, bool) {
+	sessionId, ok := r.Context().Value(sessionContextKey{}).(uuid.UUID)
+	return sessionId, ok
+}
+
+// POST /api/login
+func (config *ApiConfig) Login(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	requestParams := loginRequest{}
+	err := decoder.Decode(&requestParams)
+
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		errorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Unknown emails fall through to checkPassword with no hash, so they
+	// fail the same way (and just as slowly) as a wrong password
+	email, _ := validateEmail(requestParams.Email)
+	user, err := config.DbConn.GetUserByEmail(r.Context(), nullableString(email))
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		errorResponse(w, http.StatusInternalServerError, "Error logging in")
+		return
+	}
+
+	if !checkPassword(user.PasswordHash, requestParams.Password) {
+		errorResponse(w, http.StatusUnauthorized, "Incorrect email or password")
+		return
+	}
+
+	err = config.startSession(r.Context(), w, user.ID)
+
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "Error logging in")
+		return
+	}
+
+	validResponse(w, http.StatusOK, mapUserResponse(user))
+	return
+}
+
+// POST /api/logout
+// Succeeds whether or not there was a session to end
+func (config *ApiConfig) Logout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, err := r.Cookie(sessionCookieName)
+
+	if err == nil {
+		err = config.DbConn.DeleteSession(r.Context(), hashToken(cookie.Value))
+
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "Error logging out")
+			return
+		}
+	}
+
+	config.clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+	return
+}
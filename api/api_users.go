@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -11,8 +12,11 @@ import (
 	"github.com/google/uuid"
 )
 
+// Email and password are optional, but go together
 type createUserRequest struct {
-	Name string `json:"name"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
 }
 
 type userResponse struct {
@@ -20,24 +24,73 @@ type userResponse struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Name      string    `json:"name"`
-	ApiKey    string    `json:"api_key"`
+	Email     *string   `json:"email"`
 }
 
-func createUserParams(name string) (database.CreateUserParams, error) {
+// The first key is only shown here, when the user is created
+type newUserResponse struct {
+	userResponse
+	ApiKey string `json:"api_key"`
+}
+
+func mapUserResponse(user database.User) userResponse {
+	return userResponse{
+		ID:        user.ID,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Name:      user.Name,
+		Email:     nullStringResponse(user.Email),
+	}
+}
+
+// Returns the validated email, or "" if the user isn't signing up for an account
+func validateAccount(email string, password string) (string, error) {
+	if email == "" && password == "" {
+		return "", nil
+	}
+
+	email, err := validateEmail(email)
+
+	if err != nil {
+		return "", err
+	}
+
+	err = validatePassword(password)
+
+	if err != nil {
+		return "", err
+	}
+
+	return email, nil
+}
+
+// Password is only hashed if given
+func createUserParams(name string, email string, password string) (database.CreateUserParams, error) {
 	newId, err := uuid.NewUUID()
 
 	if err != nil {
 		return database.CreateUserParams{}, err
 	}
 
+	var passwordHash sql.NullString
+
+	if password != "" {
+		passwordHash, err = hashPassword(password)
+
+		if err != nil {
+			return database.CreateUserParams{}, err
+		}
+	}
+
 	createdAt := time.Now()
 
-	// API key will be generated by the database
 	params := database.CreateUserParams{
-		ID:        newId,
-		Name:      name,
-		CreatedAt: createdAt,
-		UpdatedAt: createdAt,
+		ID:           newId,
+		Name:         name,
+		CreatedAt:    createdAt,
+		UpdatedAt:    createdAt,
+		Email:        nullableString(email),
+		PasswordHash: passwordHash,
 	}
 
 	return params, nil
@@ -56,7 +109,14 @@ func (config *ApiConfig) CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	dbUsrParams, err := createUserParams(requestParams.Name)
+	email, err := validateAccount(requestParams.Email, requestParams.Password)
+
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbUsrParams, err := createUserParams(requestParams.Name, email, requestParams.Password)
 
 	if err != nil {
 		log.Printf("Error creating new user params: %v", err)
@@ -64,9 +124,12 @@ func (config *ApiConfig) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// what is ctx?
-	// context.TODO() seems to be the convention?
-	newUser, err := config.DbConn.CreateUser(context.TODO(), dbUsrParams)
+	newUser, key, err := config.createUserWithKey(r.Context(), dbUsrParams)
+
+	if isUniqueViolation(err) {
+		errorResponse(w, http.StatusConflict, "Email is already registered")
+		return
+	}
 
 	if err != nil {
 		log.Printf("Error creating new user: %v", err)
@@ -74,25 +137,48 @@ func (config *ApiConfig) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	validResponse(w, http.StatusCreated, userResponse{
-		ID:        newUser.ID,
-		CreatedAt: newUser.CreatedAt,
-		UpdatedAt: newUser.UpdatedAt,
-		Name:      newUser.Name,
-		ApiKey:    newUser.ApiKey,
+	validResponse(w, http.StatusCreated, newUserResponse{
+		userResponse: mapUserResponse(newUser),
+		ApiKey:       key,
 	})
 	return
 }
 
+// Users start with one key. Both are created or neither is
+func (config *ApiConfig) createUserWithKey(ctx context.Context, params database.CreateUserParams) (database.User, string, error) {
+	tx, err := config.Db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return database.User{}, "", err
+	}
+
+	defer tx.Rollback()
+	queries := config.DbConn.WithTx(tx)
+
+	newUser, err := queries.CreateUser(ctx, params)
+
+	if err != nil {
+		return database.User{}, "", err
+	}
+
+	keyParams, key, err := createApiKeyParams(newUser.ID, defaultApiKeyName, []string{ScopeAll})
+
+	if err != nil {
+		return database.User{}, "", err
+	}
+
+	_, err = queries.CreateApiKey(ctx, keyParams)
+
+	if err != nil {
+		return database.User{}, "", err
+	}
+
+	return newUser, key, tx.Commit()
+}
+
 // GET /api/users
 func (config *ApiConfig) GetUser(w http.ResponseWriter, r *http.Request, usr database.User) {
-	validResponse(w, http.StatusOK, userResponse{
-		ID:        usr.ID,
-		CreatedAt: usr.CreatedAt,
-		UpdatedAt: usr.UpdatedAt,
-		Name:      usr.Name,
-		ApiKey:    usr.ApiKey,
-	})
+	validResponse(w, http.StatusOK, mapUserResponse(usr))
 
 	return
 }
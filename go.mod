@@ -3,9 +3,21 @@ module github.com/ajpotts01/go-blog-aggregator
 go 1.21.0
 
 require (
-	github.com/go-chi/chi/v5 v5.0.10 // indirect
-	github.com/go-chi/cors v1.2.1 // indirect
-	github.com/google/uuid v1.3.1 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/lib/pq v1.10.9 // indirect
+	github.com/go-chi/chi/v5 v5.0.10
+	github.com/go-chi/cors v1.2.1
+	github.com/google/uuid v1.3.1
+	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.9
 )
+
+require golang.org/x/net v0.28.0
+
+require (
+	github.com/coreos/go-oidc/v3 v3.11.0
+	golang.org/x/crypto v0.26.0
+	golang.org/x/oauth2 v0.22.0
+)
+
+require github.com/go-jose/go-jose/v4 v4.0.2 // indirect
+
+require golang.org/x/text v0.17.0 // indirect
@@ -13,32 +13,25 @@ import (
 	"github.com/google/uuid"
 )
 
-const createFeed = `-- name: CreateFeed :one
-INSERT INTO feeds (id, created_at, updated_at, last_fetched_at, name, url, user_id)
-VALUES ($1, $2, $3, $4, $5, $6, $7)
-RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at
+const claimFeed = `-- name: ClaimFeed :one
+UPDATE
+    feeds
+SET
+    locked_until = (now() AT TIME ZONE 'UTC')::timestamp(0) + make_interval(secs => $1::int)
+WHERE
+    id = $2
+    AND (locked_until IS NULL OR locked_until <= (now() AT TIME ZONE 'UTC')::timestamp(0))
+RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, last_status, consecutive_failures, next_fetch_at, site_url, description, language, image_url, locked_until, fetch_interval_seconds
 `
 
-type CreateFeedParams struct {
-	ID            uuid.UUID
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	LastFetchedAt sql.NullTime
-	Name          string
-	Url           string
-	UserID        uuid.UUID
+type ClaimFeedParams struct {
+	LeaseSeconds int32
+	ID           uuid.UUID
 }
 
-func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, error) {
-	row := q.db.QueryRowContext(ctx, createFeed,
-		arg.ID,
-		arg.CreatedAt,
-		arg.UpdatedAt,
-		arg.LastFetchedAt,
-		arg.Name,
-		arg.Url,
-		arg.UserID,
-	)
+// Leases one feed, unless another fetch already holds it
+func (q *Queries) ClaimFeed(ctx context.Context, arg ClaimFeedParams) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, claimFeed, arg.LeaseSeconds, arg.ID)
 	var i Feed
 	err := row.Scan(
 		&i.ID,
@@ -48,17 +41,53 @@ func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, e
 		&i.Url,
 		&i.UserID,
 		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.LastStatus,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.SiteUrl,
+		&i.Description,
+		&i.Language,
+		&i.ImageUrl,
+		&i.LockedUntil,
+		&i.FetchIntervalSeconds,
 	)
 	return i, err
 }
 
-const getFeeds = `-- name: GetFeeds :many
-SELECT id, created_at, updated_at, name, url, user_id, last_fetched_at FROM feeds
-ORDER BY created_at DESC
+const claimFeedsToFetch = `-- name: ClaimFeedsToFetch :many
+UPDATE
+    feeds
+SET
+    locked_until = (now() AT TIME ZONE 'UTC')::timestamp(0) + make_interval(secs => $1::int)
+WHERE
+    id IN (
+        SELECT
+            id
+        FROM
+            feeds
+        WHERE
+            (next_fetch_at IS NULL OR next_fetch_at <= (now() AT TIME ZONE 'UTC')::timestamp(0))
+            AND (locked_until IS NULL OR locked_until <= (now() AT TIME ZONE 'UTC')::timestamp(0))
+        ORDER BY
+            next_fetch_at NULLS FIRST
+        LIMIT $2
+        FOR UPDATE SKIP LOCKED
+    )
+RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, last_status, consecutive_failures, next_fetch_at, site_url, description, language, image_url, locked_until, fetch_interval_seconds
 `
 
-func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
-	rows, err := q.db.QueryContext(ctx, getFeeds)
+type ClaimFeedsToFetchParams struct {
+	LeaseSeconds int32
+	Limit        int32
+}
+
+// Leases due feeds to the caller. SKIP LOCKED keeps concurrent instances
+// from waiting on, or claiming, each other's rows
+func (q *Queries) ClaimFeedsToFetch(ctx context.Context, arg ClaimFeedsToFetchParams) ([]Feed, error) {
+	rows, err := q.db.QueryContext(ctx, claimFeedsToFetch, arg.LeaseSeconds, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -74,6 +103,18 @@ func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
 			&i.Url,
 			&i.UserID,
 			&i.LastFetchedAt,
+			&i.Etag,
+			&i.LastModified,
+			&i.LastError,
+			&i.LastStatus,
+			&i.ConsecutiveFailures,
+			&i.NextFetchAt,
+			&i.SiteUrl,
+			&i.Description,
+			&i.Language,
+			&i.ImageUrl,
+			&i.LockedUntil,
+			&i.FetchIntervalSeconds,
 		); err != nil {
 			return nil, err
 		}
@@ -88,18 +129,96 @@ func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
 	return items, nil
 }
 
-const getNextFeedsToFetch = `-- name: GetNextFeedsToFetch :many
-SELECT
-    id, created_at, updated_at, name, url, user_id, last_fetched_at
-FROM
+const createFeed = `-- name: CreateFeed :one
+INSERT INTO feeds (id, created_at, updated_at, last_fetched_at, name, url, user_id, site_url, description, language, image_url)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+RETURNING id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, last_status, consecutive_failures, next_fetch_at, site_url, description, language, image_url, locked_until, fetch_interval_seconds
+`
+
+type CreateFeedParams struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	LastFetchedAt sql.NullTime
+	Name          string
+	Url           string
+	UserID        uuid.UUID
+	SiteUrl       sql.NullString
+	Description   sql.NullString
+	Language      sql.NullString
+	ImageUrl      sql.NullString
+}
+
+func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, createFeed,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.LastFetchedAt,
+		arg.Name,
+		arg.Url,
+		arg.UserID,
+		arg.SiteUrl,
+		arg.Description,
+		arg.Language,
+		arg.ImageUrl,
+	)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.LastStatus,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.SiteUrl,
+		&i.Description,
+		&i.Language,
+		&i.ImageUrl,
+		&i.LockedUntil,
+		&i.FetchIntervalSeconds,
+	)
+	return i, err
+}
+
+const deferFeedFetch = `-- name: DeferFeedFetch :exec
+UPDATE
     feeds
-ORDER BY
-    last_fetched_at NULLS FIRST
-LIMIT $1
+SET
+    next_fetch_at = (now() AT TIME ZONE 'UTC')::timestamp(0) + make_interval(secs => $1::int),
+    locked_until = NULL
+WHERE
+    id = $2
+    AND locked_until = $3
+`
+
+type DeferFeedFetchParams struct {
+	DelaySeconds int32
+	ID           uuid.UUID
+	LockedUntil  sql.NullTime
+}
+
+// Hands a feed back without fetching it. It's due again after the delay, so
+// it doesn't stay at the front of the queue in the meantime
+func (q *Queries) DeferFeedFetch(ctx context.Context, arg DeferFeedFetchParams) error {
+	_, err := q.db.ExecContext(ctx, deferFeedFetch, arg.DelaySeconds, arg.ID, arg.LockedUntil)
+	return err
+}
+
+const getFeeds = `-- name: GetFeeds :many
+SELECT id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, last_status, consecutive_failures, next_fetch_at, site_url, description, language, image_url, locked_until, fetch_interval_seconds FROM feeds
+ORDER BY created_at DESC
 `
 
-func (q *Queries) GetNextFeedsToFetch(ctx context.Context, limit int32) ([]Feed, error) {
-	rows, err := q.db.QueryContext(ctx, getNextFeedsToFetch, limit)
+func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
+	rows, err := q.db.QueryContext(ctx, getFeeds)
 	if err != nil {
 		return nil, err
 	}
@@ -115,6 +234,18 @@ func (q *Queries) GetNextFeedsToFetch(ctx context.Context, limit int32) ([]Feed,
 			&i.Url,
 			&i.UserID,
 			&i.LastFetchedAt,
+			&i.Etag,
+			&i.LastModified,
+			&i.LastError,
+			&i.LastStatus,
+			&i.ConsecutiveFailures,
+			&i.NextFetchAt,
+			&i.SiteUrl,
+			&i.Description,
+			&i.Language,
+			&i.ImageUrl,
+			&i.LockedUntil,
+			&i.FetchIntervalSeconds,
 		); err != nil {
 			return nil, err
 		}
@@ -129,17 +260,173 @@ func (q *Queries) GetNextFeedsToFetch(ctx context.Context, limit int32) ([]Feed,
 	return items, nil
 }
 
+const getFeedById = `-- name: GetFeedById :one
+SELECT
+    id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, last_status, consecutive_failures, next_fetch_at, site_url, description, language, image_url, locked_until, fetch_interval_seconds
+FROM
+    feeds
+WHERE
+    id = $1
+`
+
+func (q *Queries) GetFeedById(ctx context.Context, id uuid.UUID) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, getFeedById, id)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.LastStatus,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.SiteUrl,
+		&i.Description,
+		&i.Language,
+		&i.ImageUrl,
+		&i.LockedUntil,
+		&i.FetchIntervalSeconds,
+	)
+	return i, err
+}
+
+const getFeedByUrl = `-- name: GetFeedByUrl :one
+SELECT
+    id, created_at, updated_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, last_status, consecutive_failures, next_fetch_at, site_url, description, language, image_url, locked_until, fetch_interval_seconds
+FROM
+    feeds
+WHERE
+    url = $1
+`
+
+func (q *Queries) GetFeedByUrl(ctx context.Context, url string) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, getFeedByUrl, url)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.LastStatus,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.SiteUrl,
+		&i.Description,
+		&i.Language,
+		&i.ImageUrl,
+		&i.LockedUntil,
+		&i.FetchIntervalSeconds,
+	)
+	return i, err
+}
+
 const markFeedFetched = `-- name: MarkFeedFetched :exec
 UPDATE
     feeds
 SET
-    last_fetched_at = now()::timestamp(0),
-    updated_at = now()::timestamp(0)
+    last_fetched_at = (now() AT TIME ZONE 'UTC')::timestamp(0),
+    updated_at = (now() AT TIME ZONE 'UTC')::timestamp(0),
+    etag = $2,
+    last_modified = $3,
+    last_status = $4,
+    last_error = NULL,
+    consecutive_failures = 0,
+    next_fetch_at = $5,
+    fetch_interval_seconds = $6,
+    locked_until = NULL
+WHERE
+    id = $1
+    AND locked_until = $7
+`
+
+type MarkFeedFetchedParams struct {
+	ID                   uuid.UUID
+	Etag                 sql.NullString
+	LastModified         sql.NullString
+	LastStatus           sql.NullInt32
+	NextFetchAt          sql.NullTime
+	FetchIntervalSeconds sql.NullInt32
+	LockedUntil          sql.NullTime
+}
+
+func (q *Queries) MarkFeedFetched(ctx context.Context, arg MarkFeedFetchedParams) error {
+	_, err := q.db.ExecContext(ctx, markFeedFetched,
+		arg.ID,
+		arg.Etag,
+		arg.LastModified,
+		arg.LastStatus,
+		arg.NextFetchAt,
+		arg.FetchIntervalSeconds,
+		arg.LockedUntil,
+	)
+	return err
+}
+
+const markFeedFetchFailed = `-- name: MarkFeedFetchFailed :exec
+UPDATE
+    feeds
+SET
+    last_fetched_at = (now() AT TIME ZONE 'UTC')::timestamp(0),
+    updated_at = (now() AT TIME ZONE 'UTC')::timestamp(0),
+    last_error = $2,
+    last_status = $3,
+    consecutive_failures = consecutive_failures + 1,
+    next_fetch_at = $4,
+    locked_until = NULL
 WHERE
     id = $1
+    AND locked_until = $5
 `
 
-func (q *Queries) MarkFeedFetched(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, markFeedFetched, id)
+type MarkFeedFetchFailedParams struct {
+	ID          uuid.UUID
+	LastError   sql.NullString
+	LastStatus  sql.NullInt32
+	NextFetchAt sql.NullTime
+	LockedUntil sql.NullTime
+}
+
+func (q *Queries) MarkFeedFetchFailed(ctx context.Context, arg MarkFeedFetchFailedParams) error {
+	_, err := q.db.ExecContext(ctx, markFeedFetchFailed,
+		arg.ID,
+		arg.LastError,
+		arg.LastStatus,
+		arg.NextFetchAt,
+		arg.LockedUntil,
+	)
+	return err
+}
+
+const releaseFeedLease = `-- name: ReleaseFeedLease :exec
+UPDATE
+    feeds
+SET
+    locked_until = NULL
+WHERE
+    id = $1
+    AND locked_until = $2
+`
+
+type ReleaseFeedLeaseParams struct {
+	ID          uuid.UUID
+	LockedUntil sql.NullTime
+}
+
+// Every write that ends a lease checks it's still the caller's. A fetch
+// that outlived its lease leaves the new holder's alone
+func (q *Queries) ReleaseFeedLease(ctx context.Context, arg ReleaseFeedLeaseParams) error {
+	_, err := q.db.ExecContext(ctx, releaseFeedLease, arg.ID, arg.LockedUntil)
 	return err
 }
@@ -7,15 +7,16 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 const createFollow = `-- name: CreateFollow :one
-INSERT INTO follows (id, created_at, updated_at, feed_id, user_id)
-VALUES ($1, $2, $3, $4, $5)
-RETURNING id, created_at, updated_at, feed_id, user_id
+INSERT INTO follows (id, created_at, updated_at, feed_id, user_id, folder)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at, updated_at, feed_id, user_id, folder
 `
 
 type CreateFollowParams struct {
@@ -24,6 +25,7 @@ type CreateFollowParams struct {
 	UpdatedAt time.Time
 	FeedID    uuid.UUID
 	UserID    uuid.UUID
+	Folder    sql.NullString
 }
 
 func (q *Queries) CreateFollow(ctx context.Context, arg CreateFollowParams) (Follow, error) {
@@ -33,6 +35,7 @@ func (q *Queries) CreateFollow(ctx context.Context, arg CreateFollowParams) (Fol
 		arg.UpdatedAt,
 		arg.FeedID,
 		arg.UserID,
+		arg.Folder,
 	)
 	var i Follow
 	err := row.Scan(
@@ -41,6 +44,7 @@ func (q *Queries) CreateFollow(ctx context.Context, arg CreateFollowParams) (Fol
 		&i.UpdatedAt,
 		&i.FeedID,
 		&i.UserID,
+		&i.Folder,
 	)
 	return i, err
 }
@@ -64,9 +68,83 @@ func (q *Queries) DeleteFollow(ctx context.Context, arg DeleteFollowParams) erro
 	return err
 }
 
+const getFollowByFeed = `-- name: GetFollowByFeed :one
+SELECT
+    id, created_at, updated_at, feed_id, user_id, folder
+FROM
+    follows
+WHERE
+    user_id = $1
+    AND feed_id = $2
+LIMIT 1
+`
+
+type GetFollowByFeedParams struct {
+	UserID uuid.UUID
+	FeedID uuid.UUID
+}
+
+func (q *Queries) GetFollowByFeed(ctx context.Context, arg GetFollowByFeedParams) (Follow, error) {
+	row := q.db.QueryRowContext(ctx, getFollowByFeed, arg.UserID, arg.FeedID)
+	var i Follow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FeedID,
+		&i.UserID,
+		&i.Folder,
+	)
+	return i, err
+}
+
+const getFollowedFeeds = `-- name: GetFollowedFeeds :many
+SELECT
+    FD.name,
+    FD.url,
+    FW.folder
+FROM
+    follows FW
+    INNER JOIN feeds FD ON FW.feed_id = FD.id
+WHERE
+    FW.user_id = $1
+ORDER BY
+    FW.folder NULLS FIRST,
+    FD.name
+`
+
+type GetFollowedFeedsRow struct {
+	Name   string
+	Url    string
+	Folder sql.NullString
+}
+
+func (q *Queries) GetFollowedFeeds(ctx context.Context, userID uuid.UUID) ([]GetFollowedFeedsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getFollowedFeeds, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFollowedFeedsRow
+	for rows.Next() {
+		var i GetFollowedFeedsRow
+		if err := rows.Scan(&i.Name, &i.Url, &i.Folder); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getFollows = `-- name: GetFollows :many
 SELECT 
-    id, created_at, updated_at, feed_id, user_id 
+    id, created_at, updated_at, feed_id, user_id, folder 
 FROM 
     follows
 WHERE
@@ -90,6 +168,7 @@ func (q *Queries) GetFollows(ctx context.Context, userID uuid.UUID) ([]Follow, e
 			&i.UpdatedAt,
 			&i.FeedID,
 			&i.UserID,
+			&i.Folder,
 		); err != nil {
 			return nil, err
 		}
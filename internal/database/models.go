@@ -11,14 +11,39 @@ import (
 	"github.com/google/uuid"
 )
 
+type ApiKey struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	KeyHash    string
+	Prefix     string
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+	Scopes     []string
+}
+
 type Feed struct {
-	ID            uuid.UUID
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	Name          string
-	Url           string
-	UserID        uuid.UUID
-	LastFetchedAt sql.NullTime
+	ID                   uuid.UUID
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	Name                 string
+	Url                  string
+	UserID               uuid.UUID
+	LastFetchedAt        sql.NullTime
+	Etag                 sql.NullString
+	LastModified         sql.NullString
+	LastError            sql.NullString
+	LastStatus           sql.NullInt32
+	ConsecutiveFailures  int32
+	NextFetchAt          sql.NullTime
+	SiteUrl              sql.NullString
+	Description          sql.NullString
+	Language             sql.NullString
+	ImageUrl             sql.NullString
+	LockedUntil          sql.NullTime
+	FetchIntervalSeconds sql.NullInt32
 }
 
 type Follow struct {
@@ -27,23 +52,52 @@ type Follow struct {
 	UpdatedAt time.Time
 	FeedID    uuid.UUID
 	UserID    uuid.UUID
+	Folder    sql.NullString
 }
 
 type Post struct {
-	ID          uuid.UUID
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	Title       string
-	Url         string
-	Description sql.NullString
-	PublishedAt sql.NullTime
-	FeedID      uuid.UUID
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Title        string
+	Url          string
+	Description  sql.NullString
+	PublishedAt  time.Time
+	FeedID       uuid.UUID
+	Content      sql.NullString
+	ContentType  sql.NullString
+	Excerpt      sql.NullString
+	Guid         sql.NullString
+	CanonicalUrl string
+}
+
+type PostRead struct {
+	UserID uuid.UUID
+	PostID uuid.UUID
+	ReadAt time.Time
+}
+
+type Session struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	TokenHash string
+	ExpiresAt time.Time
 }
 
 type User struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Name         string
+	Email        sql.NullString
+	PasswordHash sql.NullString
+}
+
+type UserIdentity struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Name      string
-	ApiKey    string
+	UserID    uuid.UUID
+	Issuer    string
+	Subject   string
 }
@@ -11,37 +11,111 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
-const createPost = `-- name: CreatePost :one
-INSERT INTO posts (id, created_at, updated_at, title, url, description, published_at, feed_id)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-RETURNING id, created_at, updated_at, title, url, description, published_at, feed_id
+const getExistingPosts = `-- name: GetExistingPosts :many
+SELECT
+    id, created_at, updated_at, title, url, description, published_at, feed_id, content, content_type, excerpt, guid, canonical_url
+FROM
+    posts
+WHERE
+    feed_id = $1
+    AND (
+        guid = ANY($2::text[])
+        OR canonical_url = ANY($3::text[])
+    )
+ORDER BY
+    created_at
 `
 
-type CreatePostParams struct {
-	ID          uuid.UUID
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	Title       string
-	Url         string
-	Description sql.NullString
-	PublishedAt sql.NullTime
-	FeedID      uuid.UUID
+type GetExistingPostsParams struct {
+	FeedID        uuid.UUID
+	Guids         []string
+	CanonicalUrls []string
 }
 
-func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (Post, error) {
-	row := q.db.QueryRowContext(ctx, createPost,
-		arg.ID,
-		arg.CreatedAt,
-		arg.UpdatedAt,
-		arg.Title,
-		arg.Url,
-		arg.Description,
-		arg.PublishedAt,
-		arg.FeedID,
-	)
-	var i Post
+func (q *Queries) GetExistingPosts(ctx context.Context, arg GetExistingPostsParams) ([]Post, error) {
+	rows, err := q.db.QueryContext(ctx, getExistingPosts, arg.FeedID, pq.Array(arg.Guids), pq.Array(arg.CanonicalUrls))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Post
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Title,
+			&i.Url,
+			&i.Description,
+			&i.PublishedAt,
+			&i.FeedID,
+			&i.Content,
+			&i.ContentType,
+			&i.Excerpt,
+			&i.Guid,
+			&i.CanonicalUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPostForUser = `-- name: GetPostForUser :one
+SELECT
+    p.id, p.created_at, p.updated_at, p.title, p.url, p.description, p.published_at, p.feed_id, p.content, p.content_type, p.excerpt, p.guid, p.canonical_url,
+    FD.name as feed_name,
+    FD.url as feed_url,
+    (PR.post_id IS NOT NULL)::boolean as is_read
+FROM
+    posts P
+    INNER JOIN feeds FD ON P.feed_id = FD.id
+    INNER JOIN follows FW ON FD.id = FW.feed_id
+    LEFT JOIN post_reads PR ON P.id = PR.post_id AND PR.user_id = FW.user_id
+WHERE
+    P.id = $1
+    AND FW.user_id = $2
+LIMIT 1
+`
+
+type GetPostForUserParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+type GetPostForUserRow struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Title        string
+	Url          string
+	Description  sql.NullString
+	PublishedAt  time.Time
+	FeedID       uuid.UUID
+	Content      sql.NullString
+	ContentType  sql.NullString
+	Excerpt      sql.NullString
+	Guid         sql.NullString
+	CanonicalUrl string
+	FeedName     string
+	FeedUrl      string
+	IsRead       bool
+}
+
+func (q *Queries) GetPostForUser(ctx context.Context, arg GetPostForUserParams) (GetPostForUserRow, error) {
+	row := q.db.QueryRowContext(ctx, getPostForUser, arg.ID, arg.UserID)
+	var i GetPostForUserRow
 	err := row.Scan(
 		&i.ID,
 		&i.CreatedAt,
@@ -51,47 +125,87 @@ func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (Post, e
 		&i.Description,
 		&i.PublishedAt,
 		&i.FeedID,
+		&i.Content,
+		&i.ContentType,
+		&i.Excerpt,
+		&i.Guid,
+		&i.CanonicalUrl,
+		&i.FeedName,
+		&i.FeedUrl,
+		&i.IsRead,
 	)
 	return i, err
 }
 
 const getPostsByUser = `-- name: GetPostsByUser :many
 SELECT
-    p.id, p.created_at, p.updated_at, p.title, p.url, p.description, p.published_at, p.feed_id,
+    p.id, p.created_at, p.updated_at, p.title, p.url, p.description, p.published_at, p.feed_id, p.content, p.content_type, p.excerpt, p.guid, p.canonical_url,
     FD.name as feed_name,
-    FD.url as feed_url
+    FD.url as feed_url,
+    (PR.post_id IS NOT NULL)::boolean as is_read
 FROM
     posts P
     INNER JOIN feeds FD ON P.feed_id = FD.id
     INNER JOIN follows FW ON FD.id = FW.feed_id
+    LEFT JOIN post_reads PR ON P.id = PR.post_id AND PR.user_id = FW.user_id
 WHERE
     FW.user_id = $1
+    AND ($2::uuid IS NULL OR P.feed_id = $2)
+    AND ($3::timestamp IS NULL OR P.published_at >= $3)
+    AND ($4::timestamp IS NULL OR P.published_at < $4)
+    AND (
+        $5::timestamp IS NULL
+        OR (P.published_at, P.id) < ($5, $6::uuid)
+    )
+    AND (NOT $7::boolean OR PR.post_id IS NULL)
 ORDER BY
-    published_at DESC
+    P.published_at DESC,
+    P.id DESC
 LIMIT
-    $2
+    $8
 `
 
 type GetPostsByUserParams struct {
-	UserID uuid.UUID
-	Limit  int32
+	UserID            uuid.UUID
+	FeedID            uuid.NullUUID
+	Since             sql.NullTime
+	Until             sql.NullTime
+	CursorPublishedAt sql.NullTime
+	CursorID          uuid.NullUUID
+	UnreadOnly        bool
+	Limit             int32
 }
 
 type GetPostsByUserRow struct {
-	ID          uuid.UUID
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	Title       string
-	Url         string
-	Description sql.NullString
-	PublishedAt sql.NullTime
-	FeedID      uuid.UUID
-	FeedName    string
-	FeedUrl     string
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Title        string
+	Url          string
+	Description  sql.NullString
+	PublishedAt  time.Time
+	FeedID       uuid.UUID
+	Content      sql.NullString
+	ContentType  sql.NullString
+	Excerpt      sql.NullString
+	Guid         sql.NullString
+	CanonicalUrl string
+	FeedName     string
+	FeedUrl      string
+	IsRead       bool
 }
 
 func (q *Queries) GetPostsByUser(ctx context.Context, arg GetPostsByUserParams) ([]GetPostsByUserRow, error) {
-	rows, err := q.db.QueryContext(ctx, getPostsByUser, arg.UserID, arg.Limit)
+	rows, err := q.db.QueryContext(ctx, getPostsByUser,
+		arg.UserID,
+		arg.FeedID,
+		arg.Since,
+		arg.Until,
+		arg.CursorPublishedAt,
+		arg.CursorID,
+		arg.UnreadOnly,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -108,8 +222,14 @@ func (q *Queries) GetPostsByUser(ctx context.Context, arg GetPostsByUserParams)
 			&i.Description,
 			&i.PublishedAt,
 			&i.FeedID,
+			&i.Content,
+			&i.ContentType,
+			&i.Excerpt,
+			&i.Guid,
+			&i.CanonicalUrl,
 			&i.FeedName,
 			&i.FeedUrl,
+			&i.IsRead,
 		); err != nil {
 			return nil, err
 		}
@@ -123,3 +243,237 @@ func (q *Queries) GetPostsByUser(ctx context.Context, arg GetPostsByUserParams)
 	}
 	return items, nil
 }
+
+const searchPostsForUser = `-- name: SearchPostsForUser :many
+SELECT
+    p.id, p.created_at, p.updated_at, p.title, p.url, p.description, p.published_at, p.feed_id, p.content, p.content_type, p.excerpt, p.guid, p.canonical_url,
+    FD.name as feed_name,
+    FD.url as feed_url,
+    (PR.post_id IS NOT NULL)::boolean as is_read,
+    ts_rank(
+        setweight(to_tsvector('english', P.title), 'A')
+        || setweight(to_tsvector('english', COALESCE(P.description, '')), 'B'),
+        websearch_to_tsquery('english', $1)
+    )::real as rank,
+    ts_headline(
+        'english',
+        COALESCE(P.excerpt, P.title),
+        websearch_to_tsquery('english', $1),
+        'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10'
+    )::text as snippet
+FROM
+    posts P
+    INNER JOIN feeds FD ON P.feed_id = FD.id
+    INNER JOIN follows FW ON FD.id = FW.feed_id
+    LEFT JOIN post_reads PR ON P.id = PR.post_id AND PR.user_id = FW.user_id
+WHERE
+    FW.user_id = $2
+    AND (
+        setweight(to_tsvector('english', P.title), 'A')
+        || setweight(to_tsvector('english', COALESCE(P.description, '')), 'B')
+    ) @@ websearch_to_tsquery('english', $1)
+    AND (
+        $3::real IS NULL
+        OR (
+            ts_rank(
+                setweight(to_tsvector('english', P.title), 'A')
+                || setweight(to_tsvector('english', COALESCE(P.description, '')), 'B'),
+                websearch_to_tsquery('english', $1)
+            ),
+            P.id
+        ) < ($3, $4::uuid)
+    )
+ORDER BY
+    rank DESC,
+    P.id DESC
+LIMIT
+    $5
+`
+
+type SearchPostsForUserParams struct {
+	Query      string
+	UserID     uuid.UUID
+	CursorRank sql.NullFloat64
+	CursorID   uuid.NullUUID
+	Limit      int32
+}
+
+type SearchPostsForUserRow struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Title        string
+	Url          string
+	Description  sql.NullString
+	PublishedAt  time.Time
+	FeedID       uuid.UUID
+	Content      sql.NullString
+	ContentType  sql.NullString
+	Excerpt      sql.NullString
+	Guid         sql.NullString
+	CanonicalUrl string
+	FeedName     string
+	FeedUrl      string
+	IsRead       bool
+	Rank         float32
+	Snippet      string
+}
+
+func (q *Queries) SearchPostsForUser(ctx context.Context, arg SearchPostsForUserParams) ([]SearchPostsForUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchPostsForUser,
+		arg.Query,
+		arg.UserID,
+		arg.CursorRank,
+		arg.CursorID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchPostsForUserRow
+	for rows.Next() {
+		var i SearchPostsForUserRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Title,
+			&i.Url,
+			&i.Description,
+			&i.PublishedAt,
+			&i.FeedID,
+			&i.Content,
+			&i.ContentType,
+			&i.Excerpt,
+			&i.Guid,
+			&i.CanonicalUrl,
+			&i.FeedName,
+			&i.FeedUrl,
+			&i.IsRead,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const upsertPosts = `-- name: UpsertPosts :many
+INSERT INTO posts (id, created_at, updated_at, title, url, description, published_at, feed_id, content, content_type, excerpt, guid, canonical_url)
+SELECT
+    u.id,
+    $1::timestamp,
+    $1::timestamp,
+    u.title,
+    u.url,
+    NULLIF(u.description, ''),
+    u.published_at,
+    $2::uuid,
+    NULLIF(u.content, ''),
+    NULLIF(u.content_type, ''),
+    NULLIF(u.excerpt, ''),
+    NULLIF(u.guid, ''),
+    u.canonical_url
+FROM (
+    SELECT
+        unnest($3::uuid[]) AS id,
+        unnest($4::text[]) AS title,
+        unnest($5::text[]) AS url,
+        unnest($6::text[]) AS description,
+        unnest($7::timestamp[]) AS published_at,
+        unnest($8::text[]) AS content,
+        unnest($9::text[]) AS content_type,
+        unnest($10::text[]) AS excerpt,
+        unnest($11::text[]) AS guid,
+        unnest($12::text[]) AS canonical_url
+) AS u
+ON CONFLICT (id) DO UPDATE
+SET
+    updated_at = EXCLUDED.updated_at,
+    title = EXCLUDED.title,
+    url = EXCLUDED.url,
+    canonical_url = EXCLUDED.canonical_url,
+    guid = COALESCE(posts.guid, EXCLUDED.guid),
+    description = EXCLUDED.description,
+    content = EXCLUDED.content,
+    content_type = EXCLUDED.content_type,
+    excerpt = EXCLUDED.excerpt
+WHERE
+    posts.title IS DISTINCT FROM EXCLUDED.title
+    OR posts.url IS DISTINCT FROM EXCLUDED.url
+    OR posts.canonical_url IS DISTINCT FROM EXCLUDED.canonical_url
+    OR (posts.guid IS NULL AND EXCLUDED.guid IS NOT NULL)
+    OR posts.description IS DISTINCT FROM EXCLUDED.description
+    OR posts.content IS DISTINCT FROM EXCLUDED.content
+RETURNING
+    id,
+    (xmax = 0)::boolean AS inserted
+`
+
+type UpsertPostsParams struct {
+	FetchedAt     time.Time
+	FeedID        uuid.UUID
+	Ids           []uuid.UUID
+	Titles        []string
+	Urls          []string
+	Descriptions  []string
+	PublishedAts  []time.Time
+	Contents      []string
+	ContentTypes  []string
+	Excerpts      []string
+	Guids         []string
+	CanonicalUrls []string
+}
+
+type UpsertPostsRow struct {
+	ID       uuid.UUID
+	Inserted bool
+}
+
+// Arrays hold one element per post and are unnested side by side, with
+// empty strings stored as NULL.
+// Existing rows are only touched when their title, link or content changed
+func (q *Queries) UpsertPosts(ctx context.Context, arg UpsertPostsParams) ([]UpsertPostsRow, error) {
+	rows, err := q.db.QueryContext(ctx, upsertPosts,
+		arg.FetchedAt,
+		arg.FeedID,
+		pq.Array(arg.Ids),
+		pq.Array(arg.Titles),
+		pq.Array(arg.Urls),
+		pq.Array(arg.Descriptions),
+		pq.Array(arg.PublishedAts),
+		pq.Array(arg.Contents),
+		pq.Array(arg.ContentTypes),
+		pq.Array(arg.Excerpts),
+		pq.Array(arg.Guids),
+		pq.Array(arg.CanonicalUrls),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UpsertPostsRow
+	for rows.Next() {
+		var i UpsertPostsRow
+		if err := rows.Scan(&i.ID, &i.Inserted); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
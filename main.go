@@ -2,11 +2,17 @@ package main
 
 // Import Postgres driver w/ side effects
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/ajpotts01/go-blog-aggregator/api"
 	"github.com/ajpotts01/go-blog-aggregator/internal/database"
@@ -16,6 +22,8 @@ import (
 	_ "github.com/lib/pq"
 )
 
+const shutdownTimeout = 30 * time.Second
+
 func getApiConfig(dbConnStr string) (*api.ApiConfig, error) {
 	db, err := sql.Open("postgres", dbConnStr)
 
@@ -25,32 +33,253 @@ func getApiConfig(dbConnStr string) (*api.ApiConfig, error) {
 
 	dbq := database.New(db)
 
+	// Only turn off for local development over plain HTTP
+	secureCookies, err := getEnvBool("COOKIE_SECURE", true)
+
+	if err != nil {
+		return &api.ApiConfig{}, fmt.Errorf("COOKIE_SECURE: %w", err)
+	}
+
 	return &api.ApiConfig{
-		DbConn:            dbq,
-		MaxFeedsProcessed: 5,
+		DbConn:        dbq,
+		Db:            db,
+		SecureCookies: secureCookies,
 	}, nil
 }
 
-func getApiRouterV1(config *api.ApiConfig) *chi.Mux {
+// Single sign-on is off unless OIDC_ISSUER is set
+func getOidcProvider() (*api.OidcProvider, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+
+	if issuer == "" {
+		return nil, nil
+	}
+
+	return api.NewOidcProvider(context.Background(), api.OidcConfig{
+		Issuer:       issuer,
+		ClientId:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectUrl:  os.Getenv("OIDC_REDIRECT_URL"),
+		PostLoginUrl: os.Getenv("OIDC_POST_LOGIN_URL"),
+	})
+}
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+
+	if value == "" {
+		return fallback, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+func getEnvInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+
+	if value == "" {
+		return fallback, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+func getEnvRateLimit(key string, fallback api.RateLimit) (api.RateLimit, error) {
+	value := os.Getenv(key)
+
+	if value == "" {
+		return fallback, nil
+	}
+
+	return api.ParseRateLimit(value)
+}
+
+func getEnvBool(key string, fallback bool) (bool, error) {
+	value := os.Getenv(key)
+
+	if value == "" {
+		return fallback, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
+// Durations are Go duration strings, e.g. FETCH_INTERVAL=90s
+func getSchedulerConfig() (api.SchedulerConfig, error) {
+	interval, err := getEnvDuration("FETCH_INTERVAL", 60*time.Second)
+
+	if err != nil {
+		return api.SchedulerConfig{}, fmt.Errorf("FETCH_INTERVAL: %w", err)
+	}
+
+	batchSize, err := getEnvInt("FETCH_BATCH_SIZE", 100)
+
+	if err != nil {
+		return api.SchedulerConfig{}, fmt.Errorf("FETCH_BATCH_SIZE: %w", err)
+	}
+
+	fetchTimeout, err := getEnvDuration("FETCH_TIMEOUT", 30*time.Second)
+
+	if err != nil {
+		return api.SchedulerConfig{}, fmt.Errorf("FETCH_TIMEOUT: %w", err)
+	}
+
+	workers, err := getEnvInt("FETCH_WORKERS", 10)
+
+	if err != nil {
+		return api.SchedulerConfig{}, fmt.Errorf("FETCH_WORKERS: %w", err)
+	}
+
+	perHostLimit, err := getEnvInt("FETCH_PER_HOST_LIMIT", 2)
+
+	if err != nil {
+		return api.SchedulerConfig{}, fmt.Errorf("FETCH_PER_HOST_LIMIT: %w", err)
+	}
+
+	// Long enough to cover a fetch plus storing its posts
+	leaseDuration, err := getEnvDuration("FETCH_LEASE", 5*time.Minute)
+
+	if err != nil {
+		return api.SchedulerConfig{}, fmt.Errorf("FETCH_LEASE: %w", err)
+	}
+
+	return api.SchedulerConfig{
+		Interval:      interval,
+		BatchSize:     batchSize,
+		FetchTimeout:  fetchTimeout,
+		Workers:       workers,
+		PerHostLimit:  perHostLimit,
+		LeaseDuration: leaseDuration,
+	}, nil
+}
+
+// Limits per route group, each written <requests>/<period> or "off",
+// e.g. RATE_LIMIT_READ=300/1m
+type rateLimits struct {
+	// Signing up and logging in, per client IP
+	accounts api.RateLimit
+	// Unauthenticated reads, per client IP
+	public api.RateLimit
+	// Every authenticated request, per client IP. Sits in front of the
+	// groups below, so one client can't dodge them by inventing credentials
+	clients api.RateLimit
+	// The rest are per API key or session
+	read  api.RateLimit
+	write api.RateLimit
+	// Requests that fetch feeds from elsewhere
+	fetch api.RateLimit
+}
+
+func getRateLimits() (rateLimits, error) {
+	limits := rateLimits{}
+	var err error
+
+	groups := []struct {
+		key      string
+		limit    *api.RateLimit
+		fallback api.RateLimit
+	}{
+		{"RATE_LIMIT_ACCOUNTS", &limits.accounts, api.RateLimit{Requests: 10, Period: time.Minute}},
+		{"RATE_LIMIT_PUBLIC", &limits.public, api.RateLimit{Requests: 60, Period: time.Minute}},
+		{"RATE_LIMIT_CLIENTS", &limits.clients, api.RateLimit{Requests: 300, Period: time.Minute}},
+		{"RATE_LIMIT_READ", &limits.read, api.RateLimit{Requests: 120, Period: time.Minute}},
+		{"RATE_LIMIT_WRITE", &limits.write, api.RateLimit{Requests: 60, Period: time.Minute}},
+		{"RATE_LIMIT_FETCH", &limits.fetch, api.RateLimit{Requests: 10, Period: time.Minute}},
+	}
+
+	for _, group := range groups {
+		*group.limit, err = getEnvRateLimit(group.key, group.fallback)
+
+		if err != nil {
+			return rateLimits{}, fmt.Errorf("%v: %w", group.key, err)
+		}
+	}
+
+	return limits, nil
+}
+
+func getApiRouterV1(config *api.ApiConfig, limits rateLimits) *chi.Mux {
 	const errEndpoint = "/err"
 	const readyEndpoint = "/readiness"
 	const usersEndpoint = "/users"
+	const passwordEndpoint = "/users/password"
+	const loginEndpoint = "/login"
+	const logoutEndpoint = "/logout"
+	const oidcLoginEndpoint = "/oidc/login"
+	const oidcCallbackEndpoint = "/oidc/callback"
 	const feedsEndpoint = "/feeds"
 	const followsEndpoint = "/follows"
 	const singleFollowEndpoint = "/follows/{id}"
 	const postsEndpoint = "/posts"
+	const searchPostsEndpoint = "/posts/search"
+	const singlePostEndpoint = "/posts/{id}"
+	const postReadEndpoint = "/posts/{id}/read"
+	const feedReadEndpoint = "/feeds/{id}/read"
+	const feedRefreshEndpoint = "/feeds/{id}/refresh"
+	const apiKeysEndpoint = "/api_keys"
+	const singleApiKeyEndpoint = "/api_keys/{id}"
+	const unreadCountsEndpoint = "/follows/unread"
+	const opmlEndpoint = "/opml"
+
+	accountsLimit := api.NewRateLimiter(limits.accounts, api.ClientKey)
+	publicLimit := api.NewRateLimiter(limits.public, api.ClientKey)
+	clientsLimit := api.NewRateLimiter(limits.clients, api.ClientKey)
+	readLimit := api.NewRateLimiter(limits.read, api.CredentialKey)
+	writeLimit := api.NewRateLimiter(limits.write, api.CredentialKey)
+	fetchLimit := api.NewRateLimiter(limits.fetch, api.CredentialKey)
 
 	apiRouter := chi.NewRouter()
 	apiRouter.Get(readyEndpoint, api.Ready)
 	apiRouter.Get(errEndpoint, api.Err)
-	apiRouter.Post(usersEndpoint, config.CreateUser)
-	apiRouter.Get(usersEndpoint, config.AuthMiddleware(config.GetUser))
-	apiRouter.Post(feedsEndpoint, config.AuthMiddleware(config.CreateFeed))
-	apiRouter.Get(feedsEndpoint, config.GetFeeds)
-	apiRouter.Post(followsEndpoint, config.AuthMiddleware(config.FollowFeed))
-	apiRouter.Get(followsEndpoint, config.AuthMiddleware(config.GetFollows))
-	apiRouter.Delete(singleFollowEndpoint, config.AuthMiddleware(config.UnfollowFeed))
-	apiRouter.Get(postsEndpoint, config.AuthMiddleware(config.GetPostsForUser))
+
+	apiRouter.Group(func(router chi.Router) {
+		router.Use(accountsLimit.Limit)
+		router.Post(usersEndpoint, config.CreateUser)
+		router.Post(loginEndpoint, config.Login)
+		router.Post(logoutEndpoint, config.Logout)
+
+		if config.Oidc != nil {
+			router.Get(oidcLoginEndpoint, config.OidcLogin)
+			router.Get(oidcCallbackEndpoint, config.OidcCallback)
+		}
+	})
+
+	apiRouter.Group(func(router chi.Router) {
+		router.Use(publicLimit.Limit)
+		router.Get(feedsEndpoint, config.GetFeeds)
+	})
+
+	apiRouter.Group(func(router chi.Router) {
+		router.Use(clientsLimit.Limit, readLimit.Limit)
+		router.Get(usersEndpoint, config.AuthMiddleware(api.ScopeUsersRead, config.GetUser))
+		router.Get(apiKeysEndpoint, config.AuthMiddleware(api.ScopeKeysRead, config.GetApiKeys))
+		router.Get(followsEndpoint, config.AuthMiddleware(api.ScopeFollowsRead, config.GetFollows))
+		router.Get(postsEndpoint, config.AuthMiddleware(api.ScopePostsRead, config.GetPostsForUser))
+		router.Get(searchPostsEndpoint, config.AuthMiddleware(api.ScopePostsRead, config.SearchPostsForUser))
+		router.Get(singlePostEndpoint, config.AuthMiddleware(api.ScopePostsRead, config.GetPostForUser))
+		router.Get(unreadCountsEndpoint, config.AuthMiddleware(api.ScopePostsRead, config.GetUnreadCounts))
+		router.Get(opmlEndpoint, config.AuthMiddleware(api.ScopeFollowsRead, config.ExportOpml))
+	})
+
+	apiRouter.Group(func(router chi.Router) {
+		router.Use(clientsLimit.Limit, writeLimit.Limit)
+		router.Put(passwordEndpoint, config.AuthMiddleware(api.ScopeUsersWrite, config.ChangePassword))
+		router.Post(apiKeysEndpoint, config.AuthMiddleware(api.ScopeKeysWrite, config.CreateApiKey))
+		router.Delete(singleApiKeyEndpoint, config.AuthMiddleware(api.ScopeKeysWrite, config.RevokeApiKey))
+		router.Post(followsEndpoint, config.AuthMiddleware(api.ScopeFollowsWrite, config.FollowFeed))
+		router.Delete(singleFollowEndpoint, config.AuthMiddleware(api.ScopeFollowsWrite, config.UnfollowFeed))
+		router.Post(postReadEndpoint, config.AuthMiddleware(api.ScopePostsWrite, config.MarkPostRead))
+		router.Delete(postReadEndpoint, config.AuthMiddleware(api.ScopePostsWrite, config.MarkPostUnread))
+		router.Post(feedReadEndpoint, config.AuthMiddleware(api.ScopePostsWrite, config.MarkFeedRead))
+		router.Delete(feedReadEndpoint, config.AuthMiddleware(api.ScopePostsWrite, config.MarkFeedUnread))
+	})
+
+	apiRouter.Group(func(router chi.Router) {
+		router.Use(clientsLimit.Limit, fetchLimit.Limit)
+		router.Post(feedsEndpoint, config.AuthMiddleware(api.ScopeFeedsWrite, config.CreateFeed))
+		router.Post(feedRefreshEndpoint, config.AuthMiddleware(api.ScopeFeedsWrite, config.RefreshFeed))
+		router.Post(opmlEndpoint, config.AuthMiddleware(api.ScopeFollowsWrite, config.ImportOpml))
+	})
 
 	return apiRouter
 }
@@ -69,6 +298,33 @@ func main() {
 		log.Fatalf("Error setting up database: %v", err)
 	}
 
+	// Single sign-on
+	apiConfig.Oidc, err = getOidcProvider()
+
+	if err != nil {
+		log.Fatalf("Error setting up single sign-on: %v", err)
+	}
+
+	// Fetcher
+	schedulerConfig, err := getSchedulerConfig()
+
+	if err != nil {
+		log.Fatalf("Error reading fetcher config: %v", err)
+	}
+
+	scheduler, err := api.NewScheduler(apiConfig, schedulerConfig)
+
+	if err != nil {
+		log.Fatalf("Error setting up fetcher: %v", err)
+	}
+
+	// Rate limits
+	limits, err := getRateLimits()
+
+	if err != nil {
+		log.Fatalf("Error reading rate limit config: %v", err)
+	}
+
 	// App router
 	appRouter := chi.NewRouter()
 
@@ -77,10 +333,12 @@ func main() {
 		AllowedOrigins: []string{"*"},
 		AllowedMethods: []string{"GET, POST, OPTIONS, PUT, DELETE"},
 		AllowedHeaders: []string{"*"},
+		// So browser clients can see their rate limits
+		ExposedHeaders: []string{"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 	}
 	appRouter.Use(cors.Handler(corsOptions))
 
-	appRouter.Mount("/v1", getApiRouterV1(apiConfig))
+	appRouter.Mount("/v1", getApiRouterV1(apiConfig, limits))
 
 	server := &http.Server{
 		Addr:    ":" + port,
@@ -110,8 +368,37 @@ func main() {
 	// 	}
 	// }
 
-	go apiConfig.FetchLoop()
+	// SIGINT/SIGTERM stop the fetcher and the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fetcherDone := make(chan struct{})
+	go func() {
+		scheduler.FetchLoop(ctx)
+		close(fetcherDone)
+	}()
+
+	go func() {
+		log.Printf("Now serving on port: %v", port)
+		err := server.ListenAndServe()
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+
+	if err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
 
-	log.Printf("Now serving on port: %v", port)
-	log.Fatal(server.ListenAndServe())
+	log.Printf("Waiting for in-flight fetches...")
+	<-fetcherDone
 }
@@ -0,0 +1,36 @@
+-- name: CreateApiKey :one
+INSERT INTO api_keys (id, created_at, updated_at, user_id, name, key_hash, prefix, scopes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING *;
+
+-- name: GetApiKeysByUser :many
+SELECT
+    *
+FROM
+    api_keys
+WHERE
+    user_id = $1
+    AND revoked_at IS NULL
+ORDER BY
+    created_at;
+
+-- name: RevokeApiKey :execrows
+UPDATE
+    api_keys
+SET
+    revoked_at = (now() AT TIME ZONE 'UTC')::timestamp(0),
+    updated_at = (now() AT TIME ZONE 'UTC')::timestamp(0)
+WHERE
+    id = $1
+    AND user_id = $2
+    AND revoked_at IS NULL;
+
+-- name: TouchApiKey :exec
+-- At most one write a minute per key, however busy it is
+UPDATE
+    api_keys
+SET
+    last_used_at = (now() AT TIME ZONE 'UTC')::timestamp(0)
+WHERE
+    id = $1
+    AND (last_used_at IS NULL OR last_used_at < (now() AT TIME ZONE 'UTC')::timestamp(0) - INTERVAL '1 minute');
\ No newline at end of file
@@ -1,26 +1,114 @@
 -- name: CreateFeed :one
-INSERT INTO feeds (id, created_at, updated_at, last_fetched_at, name, url, user_id)
-VALUES ($1, $2, $3, $4, $5, $6, $7)
+INSERT INTO feeds (id, created_at, updated_at, last_fetched_at, name, url, user_id, site_url, description, language, image_url)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 RETURNING *;
 
 -- name: GetFeeds :many
 SELECT * FROM feeds
 ORDER BY created_at DESC;
 
--- name: GetNextFeedsToFetch :many
+-- name: GetFeedById :one
 SELECT
     *
 FROM
     feeds
-ORDER BY
-    last_fetched_at NULLS FIRST
-LIMIT $1;
+WHERE
+    id = $1;
+
+-- name: GetFeedByUrl :one
+SELECT
+    *
+FROM
+    feeds
+WHERE
+    url = $1;
+
+-- name: ClaimFeedsToFetch :many
+-- Leases due feeds to the caller. SKIP LOCKED keeps concurrent instances
+-- from waiting on, or claiming, each other's rows
+UPDATE
+    feeds
+SET
+    locked_until = (now() AT TIME ZONE 'UTC')::timestamp(0) + make_interval(secs => sqlc.arg('lease_seconds')::int)
+WHERE
+    id IN (
+        SELECT
+            id
+        FROM
+            feeds
+        WHERE
+            (next_fetch_at IS NULL OR next_fetch_at <= (now() AT TIME ZONE 'UTC')::timestamp(0))
+            AND (locked_until IS NULL OR locked_until <= (now() AT TIME ZONE 'UTC')::timestamp(0))
+        ORDER BY
+            next_fetch_at NULLS FIRST
+        LIMIT sqlc.arg('limit')
+        FOR UPDATE SKIP LOCKED
+    )
+RETURNING *;
+
+-- name: ClaimFeed :one
+-- Leases one feed, unless another fetch already holds it
+UPDATE
+    feeds
+SET
+    locked_until = (now() AT TIME ZONE 'UTC')::timestamp(0) + make_interval(secs => sqlc.arg('lease_seconds')::int)
+WHERE
+    id = sqlc.arg('id')
+    AND (locked_until IS NULL OR locked_until <= (now() AT TIME ZONE 'UTC')::timestamp(0))
+RETURNING *;
+
+-- name: ReleaseFeedLease :exec
+-- Every write that ends a lease checks it's still the caller's. A fetch
+-- that outlived its lease leaves the new holder's alone
+UPDATE
+    feeds
+SET
+    locked_until = NULL
+WHERE
+    id = $1
+    AND locked_until = $2;
+
+-- name: DeferFeedFetch :exec
+-- Hands a feed back without fetching it. It's due again after the delay, so
+-- it doesn't stay at the front of the queue in the meantime
+UPDATE
+    feeds
+SET
+    next_fetch_at = (now() AT TIME ZONE 'UTC')::timestamp(0) + make_interval(secs => sqlc.arg('delay_seconds')::int),
+    locked_until = NULL
+WHERE
+    id = sqlc.arg('id')
+    AND locked_until = sqlc.arg('locked_until');
 
 -- name: MarkFeedFetched :exec
 UPDATE
     feeds
 SET
-    last_fetched_at = now()::timestamp(0),
-    updated_at = now()::timestamp(0)
+    last_fetched_at = (now() AT TIME ZONE 'UTC')::timestamp(0),
+    updated_at = (now() AT TIME ZONE 'UTC')::timestamp(0),
+    etag = $2,
+    last_modified = $3,
+    last_status = $4,
+    last_error = NULL,
+    consecutive_failures = 0,
+    next_fetch_at = $5,
+    fetch_interval_seconds = $6,
+    locked_until = NULL
+WHERE
+    id = $1
+    AND locked_until = $7;
+
+-- name: MarkFeedFetchFailed :exec
+UPDATE
+    feeds
+SET
+    last_fetched_at = (now() AT TIME ZONE 'UTC')::timestamp(0),
+    updated_at = (now() AT TIME ZONE 'UTC')::timestamp(0),
+    last_error = $2,
+    last_status = $3,
+    consecutive_failures = consecutive_failures + 1,
+    next_fetch_at = $4,
+    locked_until = NULL
 WHERE
-    id = $1;
\ No newline at end of file
+    id = $1
+    AND locked_until = $5;
\ No newline at end of file
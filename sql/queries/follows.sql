@@ -1,6 +1,6 @@
 -- name: CreateFollow :one
-INSERT INTO follows (id, created_at, updated_at, feed_id, user_id)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO follows (id, created_at, updated_at, feed_id, user_id, folder)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING *;
 
 -- name: GetFollows :many
@@ -19,4 +19,28 @@ FROM
     follows
 WHERE
     id = $1
-    AND user_id = $2; -- so other users can't delete each other's stuff
\ No newline at end of file
+    AND user_id = $2; -- so other users can't delete each other's stuff
+
+-- name: GetFollowByFeed :one
+SELECT
+    *
+FROM
+    follows
+WHERE
+    user_id = $1
+    AND feed_id = $2
+LIMIT 1;
+
+-- name: GetFollowedFeeds :many
+SELECT
+    FD.name,
+    FD.url,
+    FW.folder
+FROM
+    follows FW
+    INNER JOIN feeds FD ON FW.feed_id = FD.id
+WHERE
+    FW.user_id = $1
+ORDER BY
+    FW.folder NULLS FIRST,
+    FD.name;
\ No newline at end of file
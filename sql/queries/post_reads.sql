@@ -0,0 +1,66 @@
+-- name: MarkPostRead :execrows
+INSERT INTO post_reads (user_id, post_id, read_at)
+SELECT DISTINCT
+    FW.user_id,
+    P.id,
+    sqlc.arg('read_at')::timestamp
+FROM
+    posts P
+    INNER JOIN follows FW ON P.feed_id = FW.feed_id
+WHERE
+    P.id = sqlc.arg('post_id')
+    AND FW.user_id = sqlc.arg('user_id')
+ON CONFLICT (user_id, post_id) DO NOTHING;
+
+-- name: MarkPostUnread :execrows
+DELETE
+FROM
+    post_reads
+WHERE
+    user_id = $1
+    AND post_id = $2;
+
+-- name: MarkFeedRead :execrows
+INSERT INTO post_reads (user_id, post_id, read_at)
+SELECT DISTINCT
+    FW.user_id,
+    P.id,
+    sqlc.arg('read_at')::timestamp
+FROM
+    posts P
+    INNER JOIN follows FW ON P.feed_id = FW.feed_id
+WHERE
+    P.feed_id = sqlc.arg('feed_id')
+    AND FW.user_id = sqlc.arg('user_id')
+    AND P.published_at <= sqlc.arg('before')
+ON CONFLICT (user_id, post_id) DO NOTHING;
+
+-- name: MarkFeedUnread :execrows
+DELETE
+FROM
+    post_reads PR
+USING
+    posts P
+WHERE
+    PR.post_id = P.id
+    AND PR.user_id = sqlc.arg('user_id')
+    AND P.feed_id = sqlc.arg('feed_id')
+    AND P.published_at <= sqlc.arg('before');
+
+-- name: GetUnreadCounts :many
+SELECT
+    FD.id as feed_id,
+    FD.name as feed_name,
+    COUNT(DISTINCT P.id) FILTER (WHERE PR.post_id IS NULL) as unread_count
+FROM
+    follows FW
+    INNER JOIN feeds FD ON FW.feed_id = FD.id
+    LEFT JOIN posts P ON FD.id = P.feed_id
+    LEFT JOIN post_reads PR ON P.id = PR.post_id AND PR.user_id = FW.user_id
+WHERE
+    FW.user_id = $1
+GROUP BY
+    FD.id,
+    FD.name
+ORDER BY
+    FD.name;
\ No newline at end of file
@@ -1,20 +1,155 @@
--- name: CreatePost :one
-INSERT INTO posts (id, created_at, updated_at, title, url, description, published_at, feed_id)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-RETURNING *;
+-- name: UpsertPosts :many
+-- Arrays hold one element per post and are unnested side by side, with
+-- empty strings stored as NULL.
+-- Existing rows are only touched when their title, link or content changed
+INSERT INTO posts (id, created_at, updated_at, title, url, description, published_at, feed_id, content, content_type, excerpt, guid, canonical_url)
+SELECT
+    u.id,
+    sqlc.arg('fetched_at')::timestamp,
+    sqlc.arg('fetched_at')::timestamp,
+    u.title,
+    u.url,
+    NULLIF(u.description, ''),
+    u.published_at,
+    sqlc.arg('feed_id')::uuid,
+    NULLIF(u.content, ''),
+    NULLIF(u.content_type, ''),
+    NULLIF(u.excerpt, ''),
+    NULLIF(u.guid, ''),
+    u.canonical_url
+FROM (
+    SELECT
+        unnest(sqlc.arg('ids')::uuid[]) AS id,
+        unnest(sqlc.arg('titles')::text[]) AS title,
+        unnest(sqlc.arg('urls')::text[]) AS url,
+        unnest(sqlc.arg('descriptions')::text[]) AS description,
+        unnest(sqlc.arg('published_ats')::timestamp[]) AS published_at,
+        unnest(sqlc.arg('contents')::text[]) AS content,
+        unnest(sqlc.arg('content_types')::text[]) AS content_type,
+        unnest(sqlc.arg('excerpts')::text[]) AS excerpt,
+        unnest(sqlc.arg('guids')::text[]) AS guid,
+        unnest(sqlc.arg('canonical_urls')::text[]) AS canonical_url
+) AS u
+ON CONFLICT (id) DO UPDATE
+SET
+    updated_at = EXCLUDED.updated_at,
+    title = EXCLUDED.title,
+    url = EXCLUDED.url,
+    canonical_url = EXCLUDED.canonical_url,
+    guid = COALESCE(posts.guid, EXCLUDED.guid),
+    description = EXCLUDED.description,
+    content = EXCLUDED.content,
+    content_type = EXCLUDED.content_type,
+    excerpt = EXCLUDED.excerpt
+WHERE
+    posts.title IS DISTINCT FROM EXCLUDED.title
+    OR posts.url IS DISTINCT FROM EXCLUDED.url
+    OR posts.canonical_url IS DISTINCT FROM EXCLUDED.canonical_url
+    OR (posts.guid IS NULL AND EXCLUDED.guid IS NOT NULL)
+    OR posts.description IS DISTINCT FROM EXCLUDED.description
+    OR posts.content IS DISTINCT FROM EXCLUDED.content
+RETURNING
+    id,
+    (xmax = 0)::boolean AS inserted;
+
+-- name: GetExistingPosts :many
+SELECT
+    *
+FROM
+    posts
+WHERE
+    feed_id = sqlc.arg('feed_id')
+    AND (
+        guid = ANY(sqlc.arg('guids')::text[])
+        OR canonical_url = ANY(sqlc.arg('canonical_urls')::text[])
+    )
+ORDER BY
+    created_at;
+
+-- name: GetPostForUser :one
+SELECT
+    P.*,
+    FD.name as feed_name,
+    FD.url as feed_url,
+    (PR.post_id IS NOT NULL)::boolean as is_read
+FROM
+    posts P
+    INNER JOIN feeds FD ON P.feed_id = FD.id
+    INNER JOIN follows FW ON FD.id = FW.feed_id
+    LEFT JOIN post_reads PR ON P.id = PR.post_id AND PR.user_id = FW.user_id
+WHERE
+    P.id = $1
+    AND FW.user_id = $2
+LIMIT 1;
 
 -- name: GetPostsByUser :many
 SELECT
     P.*,
     FD.name as feed_name,
-    FD.url as feed_url
+    FD.url as feed_url,
+    (PR.post_id IS NOT NULL)::boolean as is_read
+FROM
+    posts P
+    INNER JOIN feeds FD ON P.feed_id = FD.id
+    INNER JOIN follows FW ON FD.id = FW.feed_id
+    LEFT JOIN post_reads PR ON P.id = PR.post_id AND PR.user_id = FW.user_id
+WHERE
+    FW.user_id = sqlc.arg('user_id')
+    AND (sqlc.narg('feed_id')::uuid IS NULL OR P.feed_id = sqlc.narg('feed_id'))
+    AND (sqlc.narg('since')::timestamp IS NULL OR P.published_at >= sqlc.narg('since'))
+    AND (sqlc.narg('until')::timestamp IS NULL OR P.published_at < sqlc.narg('until'))
+    AND (
+        sqlc.narg('cursor_published_at')::timestamp IS NULL
+        OR (P.published_at, P.id) < (sqlc.narg('cursor_published_at'), sqlc.narg('cursor_id')::uuid)
+    )
+    AND (NOT sqlc.arg('unread_only')::boolean OR PR.post_id IS NULL)
+ORDER BY
+    P.published_at DESC,
+    P.id DESC
+LIMIT
+    sqlc.arg('limit');
+
+-- name: SearchPostsForUser :many
+SELECT
+    P.*,
+    FD.name as feed_name,
+    FD.url as feed_url,
+    (PR.post_id IS NOT NULL)::boolean as is_read,
+    ts_rank(
+        setweight(to_tsvector('english', P.title), 'A')
+        || setweight(to_tsvector('english', COALESCE(P.description, '')), 'B'),
+        websearch_to_tsquery('english', sqlc.arg('query'))
+    )::real as rank,
+    ts_headline(
+        'english',
+        COALESCE(P.excerpt, P.title),
+        websearch_to_tsquery('english', sqlc.arg('query')),
+        'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10'
+    )::text as snippet
 FROM
     posts P
     INNER JOIN feeds FD ON P.feed_id = FD.id
     INNER JOIN follows FW ON FD.id = FW.feed_id
+    LEFT JOIN post_reads PR ON P.id = PR.post_id AND PR.user_id = FW.user_id
 WHERE
-    FW.user_id = $1
+    FW.user_id = sqlc.arg('user_id')
+    AND (
+        setweight(to_tsvector('english', P.title), 'A')
+        || setweight(to_tsvector('english', COALESCE(P.description, '')), 'B')
+    ) @@ websearch_to_tsquery('english', sqlc.arg('query'))
+    AND (
+        sqlc.narg('cursor_rank')::real IS NULL
+        OR (
+            ts_rank(
+                setweight(to_tsvector('english', P.title), 'A')
+                || setweight(to_tsvector('english', COALESCE(P.description, '')), 'B'),
+                websearch_to_tsquery('english', sqlc.arg('query'))
+            ),
+            P.id
+        ) < (sqlc.narg('cursor_rank'), sqlc.narg('cursor_id')::uuid)
+    )
 ORDER BY
-    published_at DESC
+    rank DESC,
+    P.id DESC
 LIMIT
-    $2;
\ No newline at end of file
+    sqlc.arg('limit');
\ No newline at end of file
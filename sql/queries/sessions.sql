@@ -0,0 +1,36 @@
+-- name: CreateSession :one
+INSERT INTO sessions (id, created_at, user_id, token_hash, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetUserBySession :one
+SELECT
+    U.*,
+    S.id as session_id
+FROM
+    sessions S
+    INNER JOIN users U ON S.user_id = U.id
+WHERE
+    S.token_hash = $1
+    AND S.expires_at > (now() AT TIME ZONE 'UTC')::timestamp(0);
+
+-- name: DeleteSession :exec
+DELETE FROM
+    sessions
+WHERE
+    token_hash = $1;
+
+-- name: DeleteOtherSessions :exec
+-- Signs a user out everywhere but the session given
+DELETE FROM
+    sessions
+WHERE
+    user_id = $1
+    AND id <> $2;
+
+-- name: DeleteExpiredSessions :exec
+DELETE FROM
+    sessions
+WHERE
+    user_id = $1
+    AND expires_at <= (now() AT TIME ZONE 'UTC')::timestamp(0);
\ No newline at end of file
@@ -0,0 +1,14 @@
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (id, created_at, user_id, issuer, subject)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetUserByIdentity :one
+SELECT
+    U.*
+FROM
+    user_identities I
+    INNER JOIN users U ON I.user_id = U.id
+WHERE
+    I.issuer = $1
+    AND I.subject = $2;
\ No newline at end of file
@@ -1,12 +1,33 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, name, api_key)
-VALUES ($1, $2, $3, $4, ENCODE(SHA256(RANDOM()::TEXT::BYTEA), 'hex'))
+INSERT INTO users (id, created_at, updated_at, name, email, password_hash)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING *; -- similar to OUTPUT in T-SQL
 
 -- name: GetUserByApiKey :one
+SELECT
+    U.*,
+    K.id as api_key_id,
+    K.scopes as api_key_scopes
+FROM
+    api_keys K
+    INNER JOIN users U ON K.user_id = U.id
+WHERE
+    K.key_hash = $1
+    AND K.revoked_at IS NULL;
+
+-- name: GetUserByEmail :one
 SELECT
     *
 FROM
     users
 WHERE
-    api_key = $1;
\ No newline at end of file
+    email = $1;
+
+-- name: UpdateUserPassword :exec
+UPDATE
+    users
+SET
+    password_hash = $2,
+    updated_at = (now() AT TIME ZONE 'UTC')::timestamp(0)
+WHERE
+    id = $1;
\ No newline at end of file
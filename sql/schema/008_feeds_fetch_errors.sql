@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE feeds
+ADD COLUMN last_error TEXT,
+ADD COLUMN last_status INTEGER,
+ADD COLUMN consecutive_failures INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN next_fetch_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE feeds
+DROP COLUMN last_error,
+DROP COLUMN last_status,
+DROP COLUMN consecutive_failures,
+DROP COLUMN next_fetch_at;
\ No newline at end of file
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Posts were previously stored without a publish date
+UPDATE posts
+SET published_at = created_at
+WHERE published_at IS NULL;
+
+ALTER TABLE posts
+ALTER COLUMN published_at SET NOT NULL;
+
+-- Timeline pages are read newest first
+CREATE INDEX posts_feed_published_at_idx ON posts (feed_id, published_at DESC, id DESC);
+
+-- +goose Down
+DROP INDEX posts_feed_published_at_idx;
+
+ALTER TABLE posts
+ALTER COLUMN published_at DROP NOT NULL;
\ No newline at end of file
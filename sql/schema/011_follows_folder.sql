@@ -0,0 +1,8 @@
+-- +goose Up
+-- Folders come from OPML imports
+ALTER TABLE follows
+ADD COLUMN folder TEXT;
+
+-- +goose Down
+ALTER TABLE follows
+DROP COLUMN folder;
\ No newline at end of file
@@ -0,0 +1,9 @@
+-- +goose Up
+-- Queries have to repeat this expression exactly for the index to be used
+CREATE INDEX posts_search_idx ON posts USING GIN ((
+    setweight(to_tsvector('english', title), 'A')
+    || setweight(to_tsvector('english', COALESCE(description, '')), 'B')
+));
+
+-- +goose Down
+DROP INDEX posts_search_idx;
\ No newline at end of file
@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE posts
+ALTER COLUMN description TYPE TEXT,
+ADD COLUMN content TEXT,
+ADD COLUMN content_type TEXT;
+
+-- +goose Down
+-- Longer descriptions are cut to fit
+ALTER TABLE posts
+DROP COLUMN content,
+DROP COLUMN content_type,
+ALTER COLUMN description TYPE VARCHAR(250) USING left(description, 250);
\ No newline at end of file
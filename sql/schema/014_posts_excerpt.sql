@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE posts
+ADD COLUMN excerpt TEXT;
+
+-- +goose Down
+ALTER TABLE posts
+DROP COLUMN excerpt;
\ No newline at end of file
@@ -0,0 +1,77 @@
+-- +goose Up
+-- Posts are unique per feed now, by GUID or canonical URL. Two feeds can
+-- carry the same post, and long URLs no longer get rejected
+ALTER TABLE posts
+DROP CONSTRAINT posts_url_key,
+ALTER COLUMN url TYPE TEXT,
+ADD COLUMN guid TEXT,
+ADD COLUMN canonical_url TEXT;
+
+UPDATE posts
+SET canonical_url = url;
+
+-- Same normalisation as canonicalUrl in api/api_dedup.go, so existing rows
+-- still match when their feed is next fetched: lowercased scheme and host,
+-- and no default port, fragment, utm_ parameters or trailing slash.
+-- Parameters are sorted by name, as url.Values.Encode does, but keep their
+-- escaping as written. URLs without a host are compared as-is, so they keep
+-- the copy above
+UPDATE posts
+SET canonical_url = normalised.canonical_url
+FROM (
+    SELECT
+        id,
+        lower(parts[1]) || '://' ||
+        CASE lower(parts[1])
+            WHEN 'http' THEN regexp_replace(lower(parts[2]), ':80$', '')
+            WHEN 'https' THEN regexp_replace(lower(parts[2]), ':443$', '')
+            ELSE lower(parts[2])
+        END ||
+        rtrim(parts[3], '/') ||
+        COALESCE('?' || (
+            SELECT
+                string_agg(param, '&' ORDER BY split_part(param, '=', 1), position)
+            FROM
+                unnest(string_to_array(parts[4], '&')) WITH ORDINALITY AS params(param, position)
+            WHERE
+                param <> ''
+                AND lower(param) NOT LIKE 'utm\_%'
+        ), '') AS canonical_url
+    FROM (
+        SELECT
+            id,
+            regexp_match(trim(url), '^([A-Za-z][A-Za-z0-9+.-]*)://([^/?#]+)([^?#]*)(?:\?([^#]*))?') AS parts
+        FROM
+            posts
+    ) AS parsed
+    WHERE
+        parts IS NOT NULL
+) AS normalised
+WHERE
+    posts.id = normalised.id;
+
+ALTER TABLE posts
+ALTER COLUMN canonical_url SET NOT NULL;
+
+CREATE UNIQUE INDEX posts_feed_guid_idx ON posts (feed_id, guid) WHERE guid IS NOT NULL;
+CREATE INDEX posts_feed_canonical_url_idx ON posts (feed_id, canonical_url);
+
+-- +goose Down
+-- Going back loses posts: ones whose URL no longer fits, and every copy of a
+-- URL but the oldest, since the old schema only allowed each URL once
+DELETE FROM posts
+WHERE length(url) > 150;
+
+DELETE FROM posts
+USING posts AS kept
+WHERE posts.url = kept.url
+AND (posts.created_at, posts.id) > (kept.created_at, kept.id);
+
+DROP INDEX posts_feed_canonical_url_idx;
+DROP INDEX posts_feed_guid_idx;
+
+ALTER TABLE posts
+DROP COLUMN canonical_url,
+DROP COLUMN guid,
+ALTER COLUMN url TYPE VARCHAR(150),
+ADD CONSTRAINT posts_url_key UNIQUE (url);
\ No newline at end of file
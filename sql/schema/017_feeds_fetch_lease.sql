@@ -0,0 +1,9 @@
+-- +goose Up
+-- Set while an instance is fetching the feed. Other instances skip it until
+-- the lease runs out, so a crashed instance only holds feeds for so long
+ALTER TABLE feeds
+ADD COLUMN locked_until TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE feeds
+DROP COLUMN locked_until;
\ No newline at end of file
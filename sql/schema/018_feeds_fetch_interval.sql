@@ -0,0 +1,14 @@
+-- +goose Up
+-- How long to wait between successful fetches, worked out from how often
+-- the feed posts and what the publisher asks for
+ALTER TABLE feeds
+ADD COLUMN fetch_interval_seconds INTEGER;
+
+-- The scheduler picks feeds by when they are next due
+CREATE INDEX feeds_next_fetch_at_idx ON feeds (next_fetch_at NULLS FIRST);
+
+-- +goose Down
+DROP INDEX feeds_next_fetch_at_idx;
+
+ALTER TABLE feeds
+DROP COLUMN fetch_interval_seconds;
\ No newline at end of file
@@ -0,0 +1,39 @@
+-- +goose Up
+-- Only a SHA-256 of each key is kept. The raw key is shown once, when it's created
+CREATE TABLE api_keys(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    key_hash VARCHAR(64) UNIQUE NOT NULL,
+    -- First few characters, so people can tell their keys apart
+    prefix VARCHAR(8) NOT NULL,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id, created_at);
+
+-- Existing keys keep working
+INSERT INTO api_keys (id, created_at, updated_at, user_id, name, key_hash, prefix)
+SELECT
+    gen_random_uuid(),
+    created_at,
+    now()::timestamp(0),
+    id,
+    'default',
+    ENCODE(SHA256(CONVERT_TO(api_key, 'UTF8')), 'hex'),
+    LEFT(api_key, 8)
+FROM
+    users;
+
+ALTER TABLE users
+DROP COLUMN api_key;
+
+-- +goose Down
+-- Hashes can't be turned back into keys, so everyone gets a new one
+ALTER TABLE users
+ADD COLUMN api_key VARCHAR(64) UNIQUE NOT NULL DEFAULT ENCODE(SHA256(RANDOM()::TEXT::BYTEA), 'hex');
+
+DROP TABLE api_keys;
\ No newline at end of file
@@ -0,0 +1,11 @@
+-- +goose Up
+-- What each key may do. '*' grants everything, which is what existing keys had
+ALTER TABLE api_keys
+ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{*}';
+
+ALTER TABLE api_keys
+ALTER COLUMN scopes DROP DEFAULT;
+
+-- +goose Down
+ALTER TABLE api_keys
+DROP COLUMN scopes;
\ No newline at end of file
@@ -0,0 +1,24 @@
+-- +goose Up
+-- Accounts are optional: users created with just a name keep using API keys.
+-- Emails are stored lowercased so uniqueness ignores case
+ALTER TABLE users
+ADD COLUMN email TEXT UNIQUE,
+ADD COLUMN password_hash TEXT;
+
+-- Only a SHA-256 of each session token is kept, like API keys
+CREATE TABLE sessions(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+
+-- +goose Down
+DROP TABLE sessions;
+
+ALTER TABLE users
+DROP COLUMN password_hash,
+DROP COLUMN email;
\ No newline at end of file